@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-isatty"
+	"github.com/meddion/llame"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Exit codes of the non-interactive mode.
+const (
+	exitOK              = 0
+	exitFailure         = 1
+	exitNoStagedChanges = 2
+	exitBackendFailure  = 3
+	exitLintFailure     = 4
+	exitTimeout         = 5
+	exitSecretsFound    = 6
+)
+
+type CommitCmd struct {
+	Print bool `short:"p" help:"Print the generated message to stdout instead of starting the TUI. Enabled automatically without a TTY."`
+	Yes   bool `short:"y" aliases:"commit" help:"Commit with the generated message without confirmation."`
+	JSON  bool `name:"json" help:"Print the generated message with metadata as JSON."`
+
+	Body   bool `xor:"body" help:"Generate a full message, explaining the changes in a body (body.enabled)."`
+	NoBody bool `xor:"body" help:"Generate a subject only."`
+
+	NoVerify   bool   `short:"n" help:"Bypass the pre-commit and commit-msg hooks."`
+	SignOff    bool   `name:"signoff" short:"s" help:"Add a Signed-off-by trailer of the committer (commit.signoff)."`
+	GPGSign    bool   `name:"gpg-sign" short:"S" xor:"sign" help:"Sign the commit with the key and format from git config (commit.sign)."`
+	NoGPGSign  bool   `name:"no-gpg-sign" xor:"sign" help:"Don't sign the commit, even if commit.gpgsign is set in git config."`
+	Author     string `placeholder:"NAME <EMAIL>" help:"Override the commit author (commit.author)."`
+	Amend      bool   `help:"Replace the last commit, giving its message to the model as context."`
+	AllowEmpty bool   `help:"Allow a commit without changes (commit.allow_empty)."`
+}
+
+// commitOptions returns the commit options of config overridden with flags.
+func (c *CommitCmd) commitOptions(cfg *llame.Config) llame.CommitOptions {
+	opts := cfg.Commit.Options()
+	opts.NoVerify = c.NoVerify
+	opts.Amend = c.Amend
+	opts.SignOff = opts.SignOff || c.SignOff
+	opts.AllowEmpty = opts.AllowEmpty || c.AllowEmpty
+
+	switch {
+	case c.GPGSign:
+		opts.Sign = llame.SignAlways
+	case c.NoGPGSign:
+		opts.Sign = llame.SignNever
+	}
+
+	if c.Author != "" {
+		opts.Author = c.Author
+	}
+
+	return opts
+}
+
+func (c *CommitCmd) Run(ctx context.Context, cfg *llame.Config) error {
+	interactive := !c.Print && !c.Yes && !c.JSON && isTerminal()
+
+	mustOpenRepo()
+
+	opts := c.commitOptions(cfg)
+	refs := newTicketRefs(cfg)
+
+	data, err := newPromptData(ctx, cfg, opts, refs)
+	if err != nil {
+		if errors.Is(err, llame.NoStagedFilesErr) {
+			if !interactive {
+				llame.Exitf(exitNoStagedChanges, "No staged files found.")
+			}
+
+			llame.Printf("No staged files found. 'git add' one of these files to proceed:\n")
+			llame.Printf(llame.MustGitStatus())
+			return nil
+		}
+		if errors.Is(err, llame.SecretsFoundErr) {
+			llame.Exitf(exitSecretsFound, "Refusing to generate a message: %s.", err)
+		}
+
+		return fmt.Errorf("failed to get 'git diff': %w", err)
+	}
+
+	llm := llame.NewLlamaCppModel(cfg.Endpoint, cfg.Timeout)
+
+	if !interactive {
+		var commitOpts *llame.CommitOptions
+		if c.Yes {
+			// Stdout is reserved for the message.
+			opts.HookOutput = os.Stderr
+			commitOpts = &opts
+		}
+
+		comp, err := newCompletionQuery(cfg, data, bodyMode(cfg, c.Body, c.NoBody))
+		if err != nil {
+			return err
+		}
+		reportRedactions(data.Redactions)
+		os.Exit(runNonInteractive(ctx, cfg, llm, comp, refs, data.Redactions, commitOpts, c.JSON))
+	}
+
+	// Show what is going to be committed before generating a message, failing early
+	// if the commit can't be made (e.g. git identity isn't set).
+	repo, err := llame.NewGitRepo()
+	if err != nil {
+		return err
+	}
+	plan, err := llame.PlanCommit(repo, opts)
+	if err != nil {
+		return fmt.Errorf("can't commit: %w", err)
+	}
+
+	initTheme(cfg.Theme)
+
+	// Both kinds of messages are prepared, since they can be switched between.
+	subjectQuery, err := newCompletionQuery(cfg, data, false)
+	if err != nil {
+		return err
+	}
+	bodyQuery, err := newCompletionQuery(cfg, data, true)
+	if err != nil {
+		return err
+	}
+	p := tea.NewProgram(initialModel(ctx, llm, subjectQuery, bodyQuery, bodyMode(cfg, c.Body, c.NoBody), cfg, refs, opts, plan, data.Redactions))
+	_, err = p.Run()
+
+	return err
+}
+
+type MessageCmd struct {
+	JSON bool `name:"json" help:"Print the generated message with metadata as JSON."`
+
+	Body   bool `xor:"body" help:"Generate a full message, explaining the changes in a body (body.enabled)."`
+	NoBody bool `xor:"body" help:"Generate a subject only."`
+}
+
+func (c *MessageCmd) Run(ctx context.Context, cfg *llame.Config) error {
+	mustOpenRepo()
+
+	refs := newTicketRefs(cfg)
+	data, err := newPromptData(ctx, cfg, llame.CommitOptions{}, refs)
+	if err != nil {
+		if errors.Is(err, llame.NoStagedFilesErr) {
+			llame.Exitf(exitNoStagedChanges, "No staged files found.")
+		}
+		if errors.Is(err, llame.SecretsFoundErr) {
+			llame.Exitf(exitSecretsFound, "Refusing to generate a message: %s.", err)
+		}
+
+		return fmt.Errorf("failed to get 'git diff': %w", err)
+	}
+
+	llm := llame.NewLlamaCppModel(cfg.Endpoint, cfg.Timeout)
+	comp, err := newCompletionQuery(cfg, data, bodyMode(cfg, c.Body, c.NoBody))
+	if err != nil {
+		return err
+	}
+	reportRedactions(data.Redactions)
+	os.Exit(runNonInteractive(ctx, cfg, llm, comp, refs, data.Redactions, nil, c.JSON))
+
+	return nil
+}
+
+// proposal is printed with --json.
+type proposal struct {
+	Message    string                 `json:"message"`
+	Committed  bool                   `json:"committed"`
+	Profile    string                 `json:"profile,omitempty"`
+	ModelType  string                 `json:"model_type"`
+	Endpoint   string                 `json:"endpoint"`
+	DurationMs int64                  `json:"duration_ms"`
+	Stats      *llame.GenerationStats `json:"stats,omitempty"`
+	Redactions []string               `json:"redactions,omitempty"` // Secrets replaced in the diff, e.g. "jwt in .env:3"
+	Error      string                 `json:"error,omitempty"`
+	ExitCode   int                    `json:"exit_code"`
+}
+
+// bodyMode tells whether to generate a full message, the flags take precedence over config.
+func bodyMode(cfg *llame.Config, body, noBody bool) bool {
+	return body || (cfg.Body.Enabled && !noBody)
+}
+
+func isTerminal() bool {
+	return isatty.IsTerminal(os.Stdout.Fd()) && isatty.IsTerminal(os.Stdin.Fd())
+}
+
+// generateMessage reads the whole model response within the request timeout.
+func generateMessage(ctx context.Context, llm *llame.LlamaModel, comp llame.CompletionQuery) (string, *llame.GenerationStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, llm.RequestTimeout)
+	defer cancel()
+
+	content, stats, err := llm.Complete(ctx, comp)
+	if stats != nil {
+		llame.Debugf("Generation stats: %s", stats)
+	}
+	if err != nil {
+		if llame.IsTimeout(err) {
+			return "", stats, fmt.Errorf("model didn't finish responding within %s: %w", llm.RequestTimeout, err)
+		}
+
+		return "", stats, fmt.Errorf("failed to read from LLM: %w", err)
+	}
+
+	return llame.WrapCommitMsg(strings.TrimSpace(content), llame.GitCommiBodyCharsMax), stats, nil
+}
+
+// runNonInteractive generates a commit message without any user interaction
+// and returns the exit code of the program. The message is committed if commitOpts isn't nil.
+func runNonInteractive(ctx context.Context, cfg *llame.Config, llm *llame.LlamaModel, comp llame.CompletionQuery,
+	refs llame.TicketRefs, redactions []llame.Redaction, commitOpts *llame.CommitOptions, asJSON bool) int {
+	start := time.Now()
+	msg, stats, err := generateMessage(ctx, llm, comp)
+	if msg != "" {
+		msg = refs.Add(msg)
+	}
+
+	p := proposal{
+		Message:    msg,
+		Profile:    cfg.Profile,
+		ModelType:  cfg.ModelType,
+		Endpoint:   cfg.Endpoint,
+		DurationMs: time.Since(start).Milliseconds(),
+		Stats:      stats,
+	}
+	for _, r := range redactions {
+		p.Redactions = append(p.Redactions, r.String())
+	}
+
+	switch {
+	case err != nil && llame.IsTimeout(err):
+		p.ExitCode = exitTimeout
+	case err != nil:
+		p.ExitCode = exitBackendFailure
+	default:
+		if err = lintCommitMsg(cfg, refs, p.Message); err != nil {
+			p.ExitCode = exitLintFailure
+		} else if commitOpts != nil {
+			if err = llame.GitCommit(ctx, p.Message, *commitOpts); err != nil {
+				p.ExitCode, err = exitFailure, fmt.Errorf("failed to commit: %w", err)
+			} else {
+				p.Committed = true
+			}
+		}
+	}
+
+	if err != nil {
+		llame.Errorf("%s", err)
+		p.Error = err.Error()
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		if err := enc.Encode(p); err != nil {
+			llame.Fatalf("Failed to encode the proposal: %s", err)
+		}
+
+		return p.ExitCode
+	}
+
+	if p.Message != "" {
+		llame.Printf("%s\n", p.Message)
+	}
+	if p.Error != "" {
+		fmt.Fprintln(os.Stderr, p.Error)
+	}
+
+	return p.ExitCode
+}
+
+// lintCommitMsg checks the message, which must follow Conventional Commits with the conventional prompt style.
+// Ticket IDs added to the subject aren't linted as scopes.
+func lintCommitMsg(cfg *llame.Config, refs llame.TicketRefs, msg string) error {
+	if err := llame.LintCommitMsg(msg); err != nil {
+		return err
+	}
+
+	if cfg.PromptStyle == llame.PromptStyleConventional {
+		return llame.LintConventionalCommitMsg(refs.Strip(msg), cfg.Conventional.Options())
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/meddion/llame"
+)
+
+type ConfigCmd struct {
+	Show ConfigShowCmd `cmd:"" default:"1" help:"Print the effective configuration and the source of every value (default)."`
+	Path ConfigPathCmd `cmd:"" help:"Print the paths of the config files."`
+}
+
+type ConfigShowCmd struct{}
+
+func (c *ConfigShowCmd) Run(cfg *llame.Config) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	var table string
+	for _, entry := range cfg.Entries() {
+		key := entry.Key
+		if i := strings.LastIndex(key, "."); i != -1 {
+			if key[:i] != table {
+				table = key[:i]
+				fmt.Fprintf(w, "\n[%s]\n", table)
+			}
+			key = key[i+1:]
+		}
+
+		fmt.Fprintf(w, "%s = %s\t# %s\n", key, entry.Value, entry.Source)
+	}
+
+	if profiles := cfg.ProfileNames(); len(profiles) > 0 {
+		fmt.Fprintf(w, "\n# Available profiles: %s\n", strings.Join(profiles, ", "))
+	}
+
+	return w.Flush()
+}
+
+type ConfigPathCmd struct{}
+
+func (c *ConfigPathCmd) Run() error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	llame.Printf("%s: %s\n", llame.LayerUser, path)
+
+	if root := repoRoot(); root != "" {
+		llame.Printf("%s: %s\n", llame.LayerRepo, filepath.Join(root, llame.RepoConfigFileName))
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os/exec"
+
+	"github.com/meddion/llame"
+)
+
+type DoctorCmd struct{}
+
+type check struct {
+	name string
+	run  func(ctx context.Context, cfg *llame.Config) (string, error)
+}
+
+var doctorChecks = []check{
+	{"git binary", checkGitBinary},
+	{"git repository", checkGitRepo},
+	{"config", checkConfig},
+	{"prompt format", checkPromptFormat},
+	{"model server", checkModelServer},
+}
+
+func (c *DoctorCmd) Run(ctx context.Context, cfg *llame.Config) error {
+	failed := 0
+	for _, check := range doctorChecks {
+		details, err := check.run(ctx, cfg)
+		if err != nil {
+			failed++
+			llame.Printf("[FAIL] %s: %s\n", check.name, err)
+			continue
+		}
+
+		llame.Printf("[ OK ] %s: %s\n", check.name, details)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(doctorChecks))
+	}
+
+	return nil
+}
+
+func checkGitBinary(ctx context.Context, _ *llame.Config) (string, error) {
+	return exec.LookPath("git")
+}
+
+func checkGitRepo(ctx context.Context, _ *llame.Config) (string, error) {
+	return llame.RepoRoot()
+}
+
+func checkConfig(ctx context.Context, _ *llame.Config) (string, error) {
+	if _, err := loadConfig(); err != nil {
+		return "", err
+	}
+
+	return "valid", nil
+}
+
+func checkPromptFormat(ctx context.Context, cfg *llame.Config) (string, error) {
+	p, ok := cfg.PromptFormats[cfg.ModelType]
+	if !ok {
+		return "", fmt.Errorf("unknown model type %q", cfg.ModelType)
+	}
+
+	if err := p.Validate(); err != nil {
+		return "", err
+	}
+
+	return cfg.ModelType, nil
+}
+
+// checkModelServer asks llama-server's /health endpoint whether a model is loaded.
+func checkModelServer(ctx context.Context, cfg *llame.Config) (string, error) {
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return "", err
+	}
+	healthURL := endpoint.ResolveReference(&url.URL{Path: "/health"})
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, healthURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(healthURL.String() + " responded with " + resp.Status)
+	}
+
+	return healthURL.String(), nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/meddion/llame"
+)
+
+type HookCmd struct {
+	Install   HookInstallCmd   `cmd:"" help:"Install a prepare-commit-msg hook, so 'git commit' comes pre-filled with a generated message."`
+	Uninstall HookUninstallCmd `cmd:"" help:"Remove the prepare-commit-msg hook and restore the one it replaced."`
+	Run       HookRunCmd       `cmd:"" help:"Write a generated message into the commit message file (run by the hook)."`
+}
+
+type HookInstallCmd struct{}
+
+func (c *HookInstallCmd) Run() error {
+	repo, err := llame.NewGitRepo()
+	if err != nil {
+		return err
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate llame executable: %w", err)
+	}
+
+	path, err := llame.InstallPrepareCommitMsgHook(repo, executable)
+	if err != nil {
+		return fmt.Errorf("failed to install the hook: %w", err)
+	}
+
+	llame.Printf("Installed %s\n", path)
+	return nil
+}
+
+type HookUninstallCmd struct{}
+
+func (c *HookUninstallCmd) Run() error {
+	repo, err := llame.NewGitRepo()
+	if err != nil {
+		return err
+	}
+
+	path, err := llame.UninstallPrepareCommitMsgHook(repo)
+	if err != nil {
+		return fmt.Errorf("failed to uninstall the hook: %w", err)
+	}
+
+	llame.Printf("Removed llame hook from %s\n", path)
+	return nil
+}
+
+type HookRunCmd struct {
+	MessageFile string `arg:"" type:"path" help:"Path to the commit message file."`
+	Source      string `arg:"" optional:"" help:"Source of the commit message: message, template, merge, squash or commit."`
+	SHA         string `arg:"" optional:"" help:"Commit SHA, given for the 'commit' source."`
+}
+
+// Run never fails, since a broken model server must not block committing.
+func (c *HookRunCmd) Run(ctx context.Context, cfg *llame.Config) error {
+	if !llame.ShouldGenerateForSource(c.Source) {
+		llame.Debugf("Skipping message generation for a commit with %q source", c.Source)
+		return nil
+	}
+
+	if err := c.run(ctx, cfg); err != nil {
+		llame.Errorf("prepare-commit-msg hook: %s", err)
+		fmt.Fprintf(os.Stderr, "llame: skipping commit message generation: %s\n", err)
+	}
+
+	return nil
+}
+
+func (c *HookRunCmd) run(ctx context.Context, cfg *llame.Config) error {
+	refs := newTicketRefs(cfg)
+	data, err := newPromptData(ctx, cfg, llame.CommitOptions{}, refs)
+	if err != nil {
+		return err
+	}
+
+	comp, err := newCompletionQuery(cfg, data, cfg.Body.Enabled)
+	if err != nil {
+		return err
+	}
+	reportRedactions(data.Redactions)
+
+	llm := llame.NewLlamaCppModel(cfg.Endpoint, cfg.Timeout)
+	msg, _, err := generateMessage(ctx, llm, comp)
+	if err != nil {
+		return err
+	}
+
+	if msg == "" {
+		return fmt.Errorf("model returned an empty message")
+	}
+
+	return llame.PrependCommitMsg(c.MessageFile, refs.Add(msg))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"runtime/debug"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/meddion/llame"
+)
+
+type PromptsCmd struct {
+	List   PromptsListCmd   `cmd:"" default:"1" help:"List supported prompt formats (default)."`
+	Render PromptsRenderCmd `cmd:"" help:"Print the final prompt for the staged changes, as sent to the model."`
+	Lint   PromptsLintCmd   `cmd:"" help:"Check the structure of prompt formats."`
+}
+
+type PromptsListCmd struct{}
+
+func (c *PromptsListCmd) Run(cfg *llame.Config) error {
+	for _, name := range slices.Sorted(maps.Keys(cfg.PromptFormats)) {
+		if name == cfg.ModelType {
+			llame.Printf("%s (selected)\n", name)
+			continue
+		}
+		llame.Printf("%s\n", name)
+	}
+
+	return nil
+}
+
+type PromptsRenderCmd struct {
+	Body   bool `xor:"body" help:"Render the prompt of a full message (body.enabled)."`
+	NoBody bool `xor:"body" help:"Render the prompt of a subject."`
+	Amend  bool `help:"Render the prompt of amending the last commit."`
+}
+
+func (c *PromptsRenderCmd) Run(ctx context.Context, cfg *llame.Config) error {
+	mustOpenRepo()
+
+	data, err := newPromptData(ctx, cfg, llame.CommitOptions{Amend: c.Amend}, newTicketRefs(cfg))
+	if err != nil {
+		if errors.Is(err, llame.NoStagedFilesErr) {
+			llame.Exitf(exitNoStagedChanges, "No staged files found.")
+		}
+		if errors.Is(err, llame.SecretsFoundErr) {
+			llame.Exitf(exitSecretsFound, "Refusing to render the prompt: %s.", err)
+		}
+
+		return fmt.Errorf("failed to get 'git diff': %w", err)
+	}
+
+	comp, err := newCompletionQuery(cfg, data, bodyMode(cfg, c.Body, c.NoBody))
+	if err != nil {
+		return err
+	}
+	reportRedactions(data.Redactions)
+	llame.Printf("%s\n", comp.Prompt)
+
+	return nil
+}
+
+type PromptsLintCmd struct {
+	Files []string `arg:"" optional:"" type:"existingfile" help:"Files of prompt formats to lint, merged over the known formats like the ones of the config directories."`
+	All   bool     `help:"Lint all the known prompt formats instead of the selected one."`
+}
+
+func (c *PromptsLintCmd) Run(cfg *llame.Config) error {
+	formats := maps.Clone(cfg.PromptFormats)
+
+	var names []string
+	switch {
+	case len(c.Files) > 0:
+		for _, path := range c.Files {
+			merged, err := formats.MergeFile(path)
+			if err != nil {
+				return err
+			}
+			names = append(names, merged...)
+		}
+	case c.All:
+		names = slices.Sorted(maps.Keys(formats))
+	default:
+		names = []string{cfg.ModelType}
+	}
+
+	failed := 0
+	for _, name := range names {
+		issues := formats[name].Lint()
+		if len(issues) == 0 {
+			llame.Printf("[ OK ] %s\n", name)
+			continue
+		}
+
+		failed++
+		for _, issue := range issues {
+			llame.Printf("[FAIL] %s: %s\n", name, issue)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d prompt formats have problems", failed, len(names))
+	}
+
+	return nil
+}
+
+type ModelsCmd struct{}
+
+func (c *ModelsCmd) Run() error {
+	modelFormats := llame.ModelPromptFormats()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MODEL\tPROMPT FORMAT")
+	for _, model := range slices.Sorted(maps.Keys(modelFormats)) {
+		fmt.Fprintf(w, "%s\t%s\n", model, modelFormats[model])
+	}
+
+	return w.Flush()
+}
+
+// version is set at build time with -ldflags "-X main.version=...".
+var version = ""
+
+type VersionCmd struct{}
+
+func (c *VersionCmd) Run() error {
+	llame.Printf("llame %s\n", buildVersion())
+	return nil
+}
+
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+
+	return "(devel)"
+}
@@ -5,8 +5,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"maps"
-	"net/url"
 	"os"
 	"os/signal"
 	"slices"
@@ -17,16 +15,49 @@ import (
 	"github.com/alecthomas/kong"
 	"github.com/go-git/go-git/v5"
 	"github.com/meddion/llame"
-
-	tea "github.com/charmbracelet/bubbletea"
 )
 
-var CLI struct {
+// Globals are flags shared by all the commands.
+type Globals struct {
+	ConfigFile    string        `name:"config" short:"c" type:"path" help:"Path to the config file. By default ~/.config/llame/config.toml is used."`
+	Profile       string        `short:"P" help:"Name of the config profile to use ($LLAME_PROFILE)."`
 	Log           bool          `short:"l" help:"Enable logs."`
 	LogDirectory  string        `short:"d" type:"path" help:"Directory where to write logs. By default /tmp and /tmp/var are tried."`
-	ModelEndpoint *url.URL      `type:"url" short:"e" env:"MODEL_ENDPOINT" default:"http://127.0.0.1:8080/completion" help:"URL to access a LLM."`
-	Timeout       time.Duration `default:"15s" short:"t" help:"Duration for which the model should respond with results."`
-	ModelType     string        `default:"mistral" short:"m" enum:"mistral,alpaca,chatml,commandr,llama2,llama3,openchat,phi3,vicuna,deepseekCoder,med42,neuralchat,nousHermes,openchatMath,orion,sauerkraut,starlingCode,yi34b,zephyr"`
+	ModelEndpoint string        `short:"e" help:"URL to access a LLM ($MODEL_ENDPOINT, default: http://127.0.0.1:8080/completion)."`
+	Timeout       time.Duration `short:"t" help:"Duration for which the model should respond with results ($LLAME_TIMEOUT, default: 15s)."`
+	ModelType     string        `short:"m" help:"Prompt format of the model, see 'llame prompts' ($LLAME_MODEL_TYPE, default: mistral)."`
+}
+
+// overrides returns the config values set with flags.
+func (g *Globals) overrides() []llame.ConfigOverride {
+	var flags []llame.ConfigOverride
+	if g.Profile != "" {
+		flags = append(flags, llame.ConfigOverride{Key: "profile", Flag: "--profile", Value: g.Profile})
+	}
+	if g.ModelEndpoint != "" {
+		flags = append(flags, llame.ConfigOverride{Key: "endpoint", Flag: "--model-endpoint", Value: g.ModelEndpoint})
+	}
+	if g.Timeout != 0 {
+		flags = append(flags, llame.ConfigOverride{Key: "timeout", Flag: "--timeout", Value: g.Timeout.String()})
+	}
+	if g.ModelType != "" {
+		flags = append(flags, llame.ConfigOverride{Key: "model_type", Flag: "--model-type", Value: g.ModelType})
+	}
+
+	return flags
+}
+
+var CLI struct {
+	Globals
+
+	Commit  CommitCmd  `cmd:"" default:"withargs" help:"Generate a commit message and commit it from the TUI (default)."`
+	Message MessageCmd `cmd:"" help:"Generate a commit message and print it to stdout."`
+	Hook    HookCmd    `cmd:"" help:"Write a generated commit message into a commit message file (prepare-commit-msg hook mode)."`
+	Prompts PromptsCmd `cmd:"" help:"Inspect prompt formats and render prompts."`
+	Models  ModelsCmd  `cmd:"" help:"List known models and the prompt formats they use."`
+	Config  ConfigCmd  `cmd:"" help:"Inspect the configuration."`
+	Doctor  DoctorCmd  `cmd:"" help:"Check that git, the config and the model server are ready to be used."`
+	Version VersionCmd `cmd:"" help:"Print the llame version."`
 }
 
 func main() {
@@ -39,43 +70,49 @@ func main() {
 		cancel()
 	}()
 
-	kongCtx := kong.Parse(&CLI)
+	kongCtx := kong.Parse(&CLI,
+		kong.Name("llame"),
+		kong.Description("llame generates commit messages for you with the help of LLMs."),
+	)
 	initLogging()
-	validateFlags(kongCtx)
 
-	_, err := llame.NewGitRepo()
-	if err != nil {
-		if errors.Is(err, git.ErrRepositoryNotExists) {
-			llame.Fatalf("Repository not found: make sure you are running this command inside a git repository.")
+	cfg, err := loadConfig()
+	switch command := commandName(kongCtx); {
+	case slices.Contains(configlessCommands, command):
+	case err != nil && slices.Contains(lenientCommands, command):
+		fmt.Fprintf(os.Stderr, "llame: invalid config, only the prompt formats are used:\n%s\n", err)
+	case err != nil:
+		llame.Fatalf("Invalid config:\n%s", err)
+	default:
+		for _, warning := range cfg.Warnings {
+			fmt.Fprintf(os.Stderr, "llame: ignoring %s\n", warning)
 		}
+	}
 
-		llame.Fatalf("Failed to open git repository: %s", err)
+	kongCtx.BindTo(rootCtx, (*context.Context)(nil))
+	if err := kongCtx.Run(&CLI.Globals, &cfg); err != nil {
+		llame.Fatalf("%s", err)
 	}
+}
 
-	model := llame.NewLlamaCppModel(CLI.ModelEndpoint.String(), CLI.Timeout)
+var (
+	// configlessCommands don't use the config, so they run whatever is in it.
+	configlessCommands = []string{"version", "models", "hook install", "hook uninstall", "config path"}
+	// lenientCommands run with an invalid config: the prompt commands only need the formats,
+	// and the doctor reports config errors itself.
+	lenientCommands = []string{"prompts list", "prompts lint", "doctor"}
+)
 
-	diff, err := llame.GitDiffStaged(rootCtx)
-	if err != nil {
-		if errors.Is(err, llame.NoStagedFilesErr) {
-			llame.Printf("No staged files found. 'git add' one of these files to proceed:\n")
-			llame.Printf(llame.MustGitStatus())
-			return
+// commandName returns the path of the selected command without its arguments, e.g. "prompts lint".
+func commandName(kongCtx *kong.Context) string {
+	var names []string
+	for _, name := range strings.Fields(kongCtx.Command()) {
+		if !strings.HasPrefix(name, "<") {
+			names = append(names, name)
 		}
-
-		llame.Fatalf("failed to get 'git diff': %s", err)
 	}
 
-	comp := llame.CompletionQuery{
-		Prompt:      newOneshotPrompt(CLI.ModelType, string(diff)),
-		NPredict:    512,
-		Temperature: 0.5,
-	}
-	llame.Debugf("Completion query: %#v", comp)
-
-	p := tea.NewProgram(initialModel(rootCtx, model, comp))
-	if _, err := p.Run(); err != nil {
-		llame.Fatalf("%s", err)
-	}
+	return strings.Join(names, " ")
 }
 
 func initLogging() {
@@ -91,7 +128,6 @@ func initLogging() {
 			llame.Fatalf("Failed to init file logging: %s", err)
 		}
 
-		llame.Debugf("Connecting to %q...", CLI.ModelEndpoint)
 		d, _ := json.MarshalIndent(CLI, "", "\t")
 		llame.Debugf("CLI arguments: %s", d)
 	} else {
@@ -99,41 +135,198 @@ func initLogging() {
 	}
 }
 
-func newOneshotPrompt(modelType, diff string) string {
-	p, ok := llame.GetPromptFormats()[modelType]
-	if !ok {
-		panic(fmt.Errorf("model of type '%s' not found", modelType))
+func configPath() (string, error) {
+	if CLI.ConfigFile != "" {
+		return CLI.ConfigFile, nil
 	}
 
-	userContent := p.UserContent("Given the following code diff, generate a concise subject for commit message " +
-		"(under 50 characters) that summarizes the change clearly and effectively:\n" + diff)
+	return llame.UserConfigPath()
+}
 
-	return userContent
+// repoRoot returns the root of the current repository or an empty string outside of one.
+func repoRoot() string {
+	root, err := llame.RepoRoot()
+	if err != nil {
+		llame.Debugf("Repository config is skipped: %s", err)
+		return ""
+	}
+
+	return root
 }
 
-func validateFlags(kongCtx *kong.Context) {
-	if kongCtx.Command() != "" {
-		llame.Fatalf("unknown command: %s", kongCtx.Command())
+func loadConfig() (llame.Config, error) {
+	path, err := configPath()
+	if err != nil {
+		return llame.DefaultConfig(), fmt.Errorf("failed to locate the config file: %w", err)
+	}
+
+	cfg, err := llame.LoadConfig(path, repoRoot(), CLI.overrides()...)
+	if err != nil {
+		return cfg, err
 	}
 
-	var enumModelTypes []string
-	for _, flag := range kongCtx.Flags() {
-		if flag.Name == "model-type" {
-			enumModelTypes = strings.Split(flag.Enum, ",")
-			slices.Sort(enumModelTypes)
+	llame.Debugf("Connecting to %q...", cfg.Endpoint)
+
+	return cfg, nil
+}
+
+func mustOpenRepo() {
+	_, err := llame.NewGitRepo()
+	if err != nil {
+		if errors.Is(err, git.ErrRepositoryNotExists) {
+			llame.Fatalf("Repository not found: make sure you are running this command inside a git repository.")
 		}
+
+		llame.Fatalf("Failed to open git repository: %s", err)
+	}
+}
+
+// newPromptData collects the staged changes of the repository. When amending,
+// the changes of the HEAD commit are included and its message is given as context.
+// Secrets are redacted from the diff, unless redact.mode refuses them with llame.SecretsFoundErr.
+func newPromptData(ctx context.Context, cfg *llame.Config, opts llame.CommitOptions, refs llame.TicketRefs) (llame.PromptData, error) {
+	repo, err := llame.NewGitRepo()
+	if err != nil {
+		return llame.PromptData{}, err
 	}
 
-	supportedModelTypes := slices.Sorted(maps.Keys(llame.GetPromptFormats()))
+	var (
+		diff    *llame.Diff
+		prevMsg string
+		initial bool
+	)
+	if opts.Amend {
+		var base string
+		if base, err = llame.AmendBase(repo); err != nil {
+			return llame.PromptData{}, err
+		}
+		if prevMsg, err = llame.HeadCommitMsg(repo); err != nil {
+			return llame.PromptData{}, err
+		}
 
-	if len(enumModelTypes) != len(supportedModelTypes) {
-		panic("len(enumModelTypes) != len(supportedModelTypes)")
+		initial = base == llame.EmptyTreeHash
+		diff, err = llame.GitDiffStagedFrom(ctx, base, cfg.Diff.Options())
+	} else {
+		if initial, err = llame.HeadUnborn(repo); err != nil {
+			return llame.PromptData{}, err
+		}
+
+		diff, err = llame.GitDiffStaged(ctx, cfg.Diff.Options())
+	}
+	if err != nil && !(opts.AllowEmpty && errors.Is(err, llame.NoStagedFilesErr)) {
+		return llame.PromptData{}, err
 	}
 
-	for i := range enumModelTypes {
-		if enumModelTypes[i] != supportedModelTypes[i] {
-			panic(fmt.Errorf("at index %d enumModelTypes = %s, but upportedModelTypes = %s",
-				i, enumModelTypes[i], supportedModelTypes[i]))
+	var redactions []llame.Redaction
+	if cfg.Redact.Enabled {
+		if redactions, err = llame.RedactDiff(diff, cfg.Redact.Options()); err != nil {
+			return llame.PromptData{}, err
+		}
+		if len(redactions) > 0 && cfg.Redact.Mode == llame.RedactModeRefuse {
+			return llame.PromptData{}, fmt.Errorf("%w: %s", llame.SecretsFoundErr, redactionList(redactions))
+		}
+	}
+
+	data := llame.NewPromptData(diff)
+	data.Redactions = redactions
+	llame.Debugf("Staged changes:\n%s", data.DiffStat)
+
+	data.PrevMsg = strings.TrimSpace(prevMsg)
+	data.Initial = initial
+	data.Branch = refs.Branch
+	data.Tickets = refs
+	data.PromptStyle = cfg.PromptStyle
+	data.BodyFormat = cfg.Body.Format
+
+	if cfg.History.Enabled {
+		// The message can be generated without the examples.
+		if style, err := llame.LearnCommitStyle(repo, cfg.History.Options()); err != nil {
+			llame.Errorf("Failed to learn the commit style from the history: %s", err)
+		} else {
+			llame.Debugf("Commit style: %+v", style.Profile)
+			data.SetHistory(style)
 		}
 	}
+
+	if cfg.PromptStyle == llame.PromptStyleConventional {
+		conventional := cfg.Conventional.Options()
+		data.Conventional = &conventional
+		data.Hints = llame.InferConventional(diff, conventional)
+		llame.Debugf("Conventional Commits hints: %+v", data.Hints)
+	}
+
+	return data, nil
+}
+
+// newCompletionQuery builds a query for a subject, or for a full message with a body.
+func newCompletionQuery(cfg *llame.Config, data llame.PromptData, body bool) (llame.CompletionQuery, error) {
+	p, ok := cfg.PromptFormats[cfg.ModelType]
+	if !ok {
+		return llame.CompletionQuery{}, fmt.Errorf("model of type '%s' not found", cfg.ModelType)
+	}
+
+	tmpls, err := cfg.PromptTemplates()
+	if err != nil {
+		return llame.CompletionQuery{}, err
+	}
+
+	prompt, err := tmpls.Prompt(p, data, body)
+	if err != nil {
+		return llame.CompletionQuery{}, fmt.Errorf("failed to render the prompt: %w", err)
+	}
+
+	comp := llame.CompletionQuery{
+		Prompt:      prompt,
+		NPredict:    cfg.Sampling.NPredict,
+		Temperature: cfg.Sampling.Temperature,
+		TopK:        cfg.Sampling.TopK,
+		TopP:        cfg.Sampling.TopP,
+	}
+	if body {
+		comp.NPredict = cfg.Body.NPredict
+	}
+	llame.Debugf("Completion query: %#v", comp)
+
+	return comp, nil
+}
+
+// redactionList describes the redactions, e.g. "jwt in .env:3, private-key in id_rsa:1".
+func redactionList(redactions []llame.Redaction) string {
+	list := make([]string, len(redactions))
+	for i, r := range redactions {
+		list[i] = r.String()
+	}
+
+	return strings.Join(list, ", ")
+}
+
+// reportRedactions tells on stderr what was redacted in the diff, stdout is reserved for the output.
+func reportRedactions(redactions []llame.Redaction) {
+	if len(redactions) > 0 {
+		fmt.Fprintf(os.Stderr, "llame: redacted %d secret(s) before prompting: %s\n", len(redactions), redactionList(redactions))
+	}
+}
+
+// newTicketRefs extracts the tickets from the name of the current branch. Messages are
+// generated without them if the branch can't be read.
+func newTicketRefs(cfg *llame.Config) llame.TicketRefs {
+	repo, err := llame.NewGitRepo()
+	if err != nil {
+		llame.Errorf("Failed to read the current branch: %s", err)
+		return llame.TicketRefs{}
+	}
+
+	branch, err := llame.CurrentBranch(repo)
+	if err != nil {
+		llame.Errorf("Failed to read the current branch: %s", err)
+		return llame.TicketRefs{}
+	}
+
+	refs, err := llame.NewTicketRefs(branch, cfg.Ticket.Options())
+	if err != nil {
+		llame.Errorf("Failed to extract the tickets of the branch: %s", err)
+	}
+	llame.Debugf("Ticket references: %+v", refs)
+
+	return refs
 }
@@ -1,103 +1,188 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"strings"
 	"time"
 
 	"github.com/charmbracelet/bubbles/help"
 	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/spinner"
+	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbles/timer"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/meddion/llame"
+	"github.com/muesli/termenv"
 
 	tea "github.com/charmbracelet/bubbletea"
 )
 
-const streamChanCapacity = 10
+const (
+	streamChanCapacity = 10
+	// hookOutputLines is the number of the last lines of hooks output shown.
+	hookOutputLines = 10
+)
 
 var (
 	textStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("250")).Render
 	errStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("196")).Render
 )
 
+func initTheme(theme llame.ThemeConfig) {
+	if theme.ColorDisabled() {
+		lipgloss.SetColorProfile(termenv.Ascii)
+	}
+
+	textStyle = lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Text)).Render
+	errStyle = lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Error)).Render
+}
+
 // tea.Msg types:
 type (
 	endOfStream struct{}
 	streamResp  struct {
-		msg  string
-		err  error
+		msg   string
+		stats *llame.GenerationStats
+		err   error
+		next  tea.Cmd
+	}
+	hookOutput struct {
+		line string
 		next tea.Cmd
 	}
+	commitResult struct {
+		err error
+	}
 	errMsg error
 )
 
 type keymap struct {
 	commit key.Binding
 	regen  key.Binding
+	abort  key.Binding
+	body   key.Binding
+	help   key.Binding
 	quit   key.Binding
 }
 
-func newKeymap() keymap {
+func newKeymap(keys llame.KeysConfig) keymap {
+	binding := func(keys []string, desc string) key.Binding {
+		return key.NewBinding(
+			key.WithKeys(keys...),
+			key.WithHelp(keys[0], desc),
+		)
+	}
+
 	return keymap{
-		commit: key.NewBinding(
-			key.WithKeys("enter"),
-			key.WithHelp("enter", "commit"),
-		),
-		regen: key.NewBinding(
-			key.WithKeys("ctrl+r"),
-			key.WithHelp("ctrl+r", "regenerate"),
-		),
-		quit: key.NewBinding(
-			key.WithKeys("q", "ctrl+c"),
-			key.WithHelp("q", "quit"),
-		),
+		commit: binding(keys.Commit, "commit"),
+		regen:  binding(keys.Regen, "regenerate"),
+		abort:  binding(keys.Abort, "abort"),
+		body:   binding(keys.Body, "toggle body"),
+		help:   binding(keys.Help, "toggle help"),
+		quit:   binding(keys.Quit, "quit"),
+	}
+}
+
+// ShortHelp implements help.KeyMap.
+func (k keymap) ShortHelp() []key.Binding {
+	return []key.Binding{k.help, k.quit}
+}
+
+// FullHelp implements help.KeyMap.
+func (k keymap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{
+		{k.commit, k.regen, k.abort, k.body},
+		{k.help, k.quit},
 	}
 }
 
 type model struct {
-	ctx             context.Context
-	llm             *llame.LlamaModel
-	llmTimeout      time.Duration
-	completionQuery llame.CompletionQuery
+	ctx          context.Context
+	cfg          *llame.Config
+	llm          *llame.LlamaModel
+	llmTimeout   time.Duration
+	subjectQuery llame.CompletionQuery
+	bodyQuery    llame.CompletionQuery
+	body         bool             // A full message is generated and edited in textArea
+	refs         llame.TicketRefs // Added to the message
 
 	spinner   spinner.Model
 	textInput textinput.Model
+	textArea  textarea.Model
 	help      help.Model
 	timer     timer.Model
 
+	// streamCtx is derived from ctx for every stream, so a single generation
+	// can be aborted (or timed out) without affecting the rest of the program.
+	streamCtx    context.Context
+	cancelStream context.CancelFunc
+
+	// Live generation stats are estimated from the received chunks (one per token)
+	// until llama-server reports the real ones with the final chunk.
+	firstTokenAt time.Time
+	liveTokens   int
+	liveTPS      float64
+	stats        *llame.GenerationStats
+
+	commitOpts   llame.CommitOptions
+	isCommitting bool
+	hookOutput   []string
+
+	header        string
 	keymap        keymap
 	isStreaming   bool
+	aborted       bool
+	composing     bool // The user has edited the message since it was generated
 	suggestions   []string
 	err           error
+	lintErr       error // Of the generated message, it can still be committed
 	msgBeforeQuit string
 }
 
-func initialModel(ctx context.Context, llm *llame.LlamaModel, comp llame.CompletionQuery) model {
+func initialModel(ctx context.Context, llm *llame.LlamaModel, subjectQuery, bodyQuery llame.CompletionQuery, body bool,
+	cfg *llame.Config, refs llame.TicketRefs, commitOpts llame.CommitOptions, plan *llame.CommitPlan, redactions []llame.Redaction) model {
 	ti := textinput.New()
 	ti.ShowSuggestions = true
 	ti.Placeholder = "Write your commit message..."
-	ti.Focus()
 	// ti.CharLimit = llame.GitCommitSubjectCharsMin + llame.GitCommiBodyCharsMax
 	ti.CharLimit = 0
 	ti.Width = llame.GitCommitSubjectCharsMin + 10
 
+	ta := textarea.New()
+	ta.Placeholder = "Write your commit message..."
+	ta.ShowLineNumbers = false
+	ta.CharLimit = 0
+	ta.SetWidth(llame.GitCommiBodyCharsMax + 2)
+	ta.SetHeight(10)
+	ta.KeyMap.InsertNewline = key.NewBinding(key.WithKeys(cfg.Keys.Newline...))
+
 	m := model{
-		ctx:             ctx,
-		llm:             llm,
-		llmTimeout:      llm.RequestTimeout,
-		completionQuery: comp,
-		textInput:       ti,
-		timer:           timer.NewWithInterval(llm.RequestTimeout, time.Second),
-		help:            help.New(),
-		keymap:          newKeymap(),
-		isStreaming:     true, // Streaming will start after m.Init()
+		ctx:          ctx,
+		cfg:          cfg,
+		llm:          llm,
+		llmTimeout:   llm.RequestTimeout,
+		subjectQuery: subjectQuery,
+		bodyQuery:    bodyQuery,
+		body:         body,
+		refs:         refs,
+		commitOpts:   commitOpts,
+		textInput:    ti,
+		textArea:     ta,
+		timer:        timer.NewWithInterval(llm.RequestTimeout, time.Second),
+		help:         help.New(),
+		keymap:       newKeymap(cfg.Keys),
+		header:       header(cfg, plan, redactions),
+		isStreaming:  true, // Streaming will start after m.Init()
 	}
 
 	m.resetSpinner()
+	m.resetStreamCtx()
+	m.focusInput()
 
 	return m
 }
@@ -116,27 +201,78 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch tMsg := msg.(type) {
 	case endOfStream:
 		m.isStreaming = false
-		m.suggestions = append(m.suggestions, m.textInput.Value())
-		m.textInput.SetSuggestions(m.suggestions)
-		return m, textinput.Blink
+		m.cancelStream()
+		if value := m.inputValue(); value != "" {
+			value = llame.WrapCommitMsg(value, llame.GitCommiBodyCharsMax)
+			// Trailers don't fit the single line input, they are added on commit.
+			if m.body || m.refs.Placement != llame.TicketTrailer {
+				value = m.refs.Add(value)
+			}
+			m.setInputValue(value)
+			if !m.body {
+				m.suggestions = append(m.suggestions, value)
+				m.textInput.SetSuggestions(m.suggestions)
+			}
+			m.lintErr = lintCommitMsg(m.cfg, m.refs, value)
+		}
+		return m, tea.Batch(textinput.Blink, m.timer.Stop())
 	case streamResp:
 		if tMsg.err != nil {
-			cmd = newErrMsg(tMsg.err)
+			// Errors caused by an abort or a timeout are already reported.
+			if m.streamCtx.Err() == nil || !errors.Is(tMsg.err, context.Canceled) {
+				cmd = newErrMsg(tMsg.err)
+			}
 		} else {
-			m.textInput, cmd = m.textInputUpdate(tea.KeyMsg{
-				Type:  tea.KeyRunes,
-				Runes: []rune(tMsg.msg),
-			})
+			m.updateStats(tMsg)
+			if m.body {
+				m.textArea.InsertString(tMsg.msg)
+			} else {
+				m.textInput, cmd = m.textInputUpdate(tea.KeyMsg{
+					Type:  tea.KeyRunes,
+					Runes: []rune(tMsg.msg),
+				})
+			}
 		}
 		return m, tea.Batch(tMsg.next, cmd)
+	case hookOutput:
+		m.hookOutput = append(m.hookOutput, tMsg.line)
+		if len(m.hookOutput) > hookOutputLines {
+			m.hookOutput = m.hookOutput[len(m.hookOutput)-hookOutputLines:]
+		}
+		return m, tMsg.next
+	case commitResult:
+		m.isCommitting = false
+		if tMsg.err != nil {
+			// The message is kept, so it can be fixed and committed again.
+			return m, newErrMsg(fmt.Errorf("failed to commit: %w", tMsg.err))
+		}
+
+		m.msgBeforeQuit = "Successfully commited ;)"
+		return m, tea.Quit
 	case errMsg:
 		m.err = tMsg
 		llame.Errorf("%s", tMsg)
 		return m, nil
 	case tea.KeyMsg:
 		switch {
+		case m.typed(tMsg):
+			// Printable keys, e.g. "q" of quit, are text once the message is being edited.
 		case key.Matches(tMsg, m.keymap.quit):
+			m.cancelStream()
 			return m, tea.Quit
+		case key.Matches(tMsg, m.keymap.help):
+			m.help.ShowAll = !m.help.ShowAll
+			return m, nil
+		case m.isCommitting:
+			return m, nil
+		case key.Matches(tMsg, m.keymap.abort):
+			if !m.isStreaming {
+				return m, nil
+			}
+			llame.Debugf("Aborting stream on user request")
+			m.aborted = true
+			m.cancelStream()
+			return m, nil
 		case key.Matches(tMsg, m.keymap.regen):
 			if m.isStreaming {
 				llame.Debugf("Stream in progress, can't restart")
@@ -146,6 +282,17 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.restartStream(),
 				m.spinner.Tick,
 			)
+		case key.Matches(tMsg, m.keymap.body):
+			if m.isStreaming {
+				llame.Debugf("Stream in progress, can't switch to another message kind")
+				return m, nil
+			}
+			m.body = !m.body
+			m.focusInput()
+			return m, tea.Batch(
+				m.restartStream(),
+				m.spinner.Tick,
+			)
 		case key.Matches(tMsg, m.keymap.commit):
 			if m.isStreaming {
 				llame.Debugf("Stream in progress, can't commit")
@@ -157,25 +304,42 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return m, newErrMsg(errors.New("cannot commit empty message"))
 			}
 
-			if err := llame.GitCommit(commitMsg); err != nil {
-				return m, newErrMsg(fmt.Errorf("failed to commit: %w", err))
-			}
+			m.err = nil
+			m.isCommitting = true
+			m.hookOutput = nil
+			m.resetSpinner()
 
-			m.msgBeforeQuit = "Successfully commited ;)"
-			return m, tea.Quit
+			return m, tea.Batch(m.startCommit(commitMsg), m.spinner.Tick)
 		}
 	case spinner.TickMsg:
 		m.spinner, cmd = m.spinner.Update(msg)
 		return m, cmd
-	case timer.TickMsg:
+	case timer.TickMsg, timer.StartStopMsg:
 		var cmd tea.Cmd
 		m.timer, cmd = m.timer.Update(msg)
 		return m, cmd
+	case timer.TimeoutMsg:
+		if m.isStreaming && m.streamCtx.Err() == nil {
+			llame.Debugf("Stream timed out after %s", m.llmTimeout)
+			m.cancelStream()
+			return m, newErrMsg(fmt.Errorf("model didn't finish responding within %s", m.llmTimeout))
+		}
+		return m, nil
 	}
 
 	// Accept user input if don't stream LLM's response
-	if !m.isStreaming {
-		m.textInput, cmd = m.textInputUpdate(msg)
+	if !m.isStreaming && !m.isCommitting {
+		if _, ok := msg.(tea.KeyMsg); ok {
+			m.composing = true
+		}
+		if m.body {
+			m.textArea, cmd = m.textArea.Update(msg)
+		} else {
+			m.textInput, cmd = m.textInputUpdate(msg)
+		}
+		if m.lintErr != nil {
+			m.lintErr = lintCommitMsg(m.cfg, m.refs, m.commitMsg())
+		}
 		return m, cmd
 	}
 
@@ -187,8 +351,16 @@ func (m model) View() (s string) {
 		return fmt.Sprintf("%s\n", textStyle(m.msgBeforeQuit))
 	}
 
+	s += textStyle(m.header) + "\n"
+
 	if m.err != nil {
 		s += fmt.Sprintf("\n%s\n", errStyle("ERROR: "+m.err.Error()))
+	} else if m.isCommitting {
+		s += fmt.Sprintf(textStyle("\n%s %s\n"), m.spinner.View(), "Committing...")
+	} else if m.aborted && m.isStreaming {
+		s += fmt.Sprintf("\n%s\n", textStyle("Aborting..."))
+	} else if m.aborted {
+		s += fmt.Sprintf("\n%s\n", textStyle("Generation aborted, partial model response:"))
 	} else if !m.isStreaming {
 		s += fmt.Sprintf("\n%s\n", textStyle("Model response:"))
 	} else {
@@ -200,42 +372,85 @@ func (m model) View() (s string) {
 		)
 	}
 
+	input := m.textInput.View()
+	if m.body {
+		input = m.textArea.View()
+	}
 	s += fmt.Sprintf(
 		"\n%s\n",
-		m.textInput.View(),
+		input,
 	)
+	if m.lintErr != nil && !m.isStreaming {
+		s += fmt.Sprintf("\n%s\n", errStyle("WARNING: "+m.lintErr.Error()))
+	}
+	if stats := m.statsView(); stats != "" {
+		s += fmt.Sprintf("\n%s\n", textStyle(stats))
+	}
+	if len(m.hookOutput) > 0 {
+		s += fmt.Sprintf("\n%s\n", textStyle(strings.Join(m.hookOutput, "\n")))
+	}
 	s += m.helpView()
 	s += "\n"
 
 	return
 }
 
+// header describes the model that generates the message, the commit to be made and
+// the secrets hidden from the model.
+func header(cfg *llame.Config, plan *llame.CommitPlan, redactions []llame.Redaction) string {
+	profile := cfg.Profile
+	if profile == "" {
+		profile = "none"
+	}
+
+	s := fmt.Sprintf("profile: %s | model: %s | %s\n%s", profile, cfg.ModelType, cfg.Endpoint, plan)
+	if len(redactions) > 0 {
+		s += fmt.Sprintf("\nredacted: %s", redactionList(redactions))
+	}
+
+	return s
+}
+
+func (m model) statsView() string {
+	if m.stats != nil {
+		return m.stats.String()
+	}
+
+	if m.liveTokens > 0 {
+		return fmt.Sprintf("generated: %d tok (%.1f tok/s)", m.liveTokens, m.liveTPS)
+	}
+
+	return ""
+}
+
 func (m model) helpView() string {
-	keybindings := make([]key.Binding, 0, 3)
+	if m.help.ShowAll {
+		return "\n" + m.help.FullHelpView(m.keymap.FullHelp())
+	}
 
-	if !m.isStreaming {
+	keybindings := make([]key.Binding, 0, 4)
+
+	switch {
+	case m.isCommitting:
+		// Nothing can be done until the hooks finish.
+	case !m.isStreaming:
 		if m.commitMsg() != "" {
 			keybindings = append(keybindings, m.keymap.commit)
 		}
-		keybindings = append(keybindings, m.keymap.regen)
+		keybindings = append(keybindings, m.keymap.regen, m.keymap.body)
+	case !m.aborted:
+		keybindings = append(keybindings, m.keymap.abort)
 	}
 
-	keybindings = append(keybindings, m.keymap.quit)
+	keybindings = append(keybindings, m.keymap.help, m.keymap.quit)
 
 	return "\n" + m.help.ShortHelpView(keybindings)
 }
 
 func (m model) startStream() tea.Cmd {
-	errCmd := func(err error) tea.Cmd {
-		return tea.Batch(newErrMsg(err), newEndOfStream())
-	}
-
-	// llame.Debugf("Start stream with the following query: %v", m.completionQuery)
-	llmStream, err := m.llm.ReadStream(m.ctx, m.completionQuery)
-	if err != nil {
-		llame.Errorf("failed to read from LLM: %w", err)
-
-		return errCmd(fmt.Errorf("failed to read from LLM: %w", err))
+	ctx, llm, query := m.streamCtx, m.llm, m.subjectQuery
+	if m.body {
+		query = m.bodyQuery
 	}
 
 	streamChan := make(chan streamResp, streamChanCapacity)
@@ -251,9 +466,20 @@ func (m model) startStream() tea.Cmd {
 		return msg
 	}
 
+	// The request is made in the background, so the UI stays responsive
+	// (and abortable) while the model is processing the prompt.
 	go func() {
 		defer close(streamChan)
 
+		// llame.Debugf("Start stream with the following query: %v", query)
+		llmStream, err := llm.ReadStream(ctx, query)
+		if err != nil {
+			llame.Errorf("failed to read from LLM: %w", err)
+
+			streamChan <- streamResp{err: fmt.Errorf("failed to read from LLM: %w", err), next: readStreamCmd}
+			return
+		}
+
 		for llmResp := range llmStream {
 			llame.Debugf("LLM response: %v", llmResp)
 
@@ -263,6 +489,9 @@ func (m model) startStream() tea.Cmd {
 				streamResp.err = llmResp.Error
 			} else {
 				streamResp.msg = llmResp.Content
+				if stats, ok := llmResp.Stats(); ok {
+					streamResp.stats = &stats
+				}
 			}
 
 			streamChan <- streamResp
@@ -274,16 +503,93 @@ func (m model) startStream() tea.Cmd {
 	return readStreamCmd
 }
 
+// startCommit commits in the background, streaming the output of git hooks line by line.
+func (m model) startCommit(commitMsg string) tea.Cmd {
+	ctx, opts := m.ctx, m.commitOpts
+
+	outChan := make(chan tea.Msg, streamChanCapacity)
+
+	var readOutputCmd tea.Cmd
+	readOutputCmd = func() tea.Msg {
+		msg := <-outChan
+		if out, ok := msg.(hookOutput); ok {
+			out.next = readOutputCmd
+			return out
+		}
+
+		return msg
+	}
+
+	go func() {
+		pr, pw := io.Pipe()
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+
+			scanner := bufio.NewScanner(pr)
+			for scanner.Scan() {
+				outChan <- hookOutput{line: scanner.Text()}
+			}
+			// Don't block the hooks if a line is too long to be scanned.
+			_, _ = io.Copy(io.Discard, pr)
+		}()
+
+		opts.HookOutput = pw
+		err := llame.GitCommit(ctx, commitMsg, opts)
+		pw.Close()
+		<-done
+
+		outChan <- commitResult{err: err}
+	}()
+
+	return readOutputCmd
+}
+
 func (m *model) restartStream() tea.Cmd {
 	m.textInput.Reset()
+	m.textArea.Reset()
 	m.resetSpinner()
 
+	m.resetStreamCtx()
+
 	m.err = nil
+	m.lintErr = nil
+	m.hookOutput = nil
+	m.stats = nil
+	m.liveTokens, m.liveTPS = 0, 0
+	m.aborted = false
+	m.composing = false
 	m.isStreaming = true
 
 	return tea.Batch(m.startStream(), m.resetTimer())
 }
 
+func (m *model) updateStats(resp streamResp) {
+	if resp.stats != nil {
+		m.stats = resp.stats
+		return
+	}
+
+	if resp.msg == "" {
+		return
+	}
+
+	m.liveTokens++
+	if m.liveTokens == 1 {
+		m.firstTokenAt = time.Now()
+	} else if elapsed := time.Since(m.firstTokenAt); elapsed > 0 {
+		m.liveTPS = float64(m.liveTokens-1) / elapsed.Seconds()
+	}
+}
+
+func (m *model) resetStreamCtx() {
+	if m.cancelStream != nil {
+		m.cancelStream()
+	}
+	m.streamCtx, m.cancelStream = context.WithCancel(m.ctx)
+}
+
 func (m *model) resetSpinner() {
 	m.spinner = spinner.New()
 	m.spinner.Spinner = spinner.Monkey
@@ -295,9 +601,42 @@ func (m *model) resetTimer() tea.Cmd {
 }
 
 func (m model) commitMsg() string {
+	return m.refs.Add(m.inputValue())
+}
+
+// inputValue is the message being edited, either a subject or a full message.
+func (m model) inputValue() string {
+	if m.body {
+		return m.textArea.Value()
+	}
 	return m.textInput.Value()
 }
 
+func (m *model) setInputValue(value string) {
+	if m.body {
+		m.textArea.SetValue(value)
+	} else {
+		m.textInput.SetValue(value)
+	}
+}
+
+// typed tells whether the key types text into the message: printable keys trigger their
+// bindings (e.g. "?" of help) until the user starts editing the message.
+func (m model) typed(msg tea.KeyMsg) bool {
+	return m.composing && !m.isCommitting && !msg.Alt && (msg.Type == tea.KeyRunes || msg.Type == tea.KeySpace)
+}
+
+// focusInput focuses the input of the current message kind, so only it shows the cursor.
+func (m *model) focusInput() {
+	if m.body {
+		m.textInput.Blur()
+		m.textArea.Focus()
+	} else {
+		m.textArea.Blur()
+		m.textInput.Focus()
+	}
+}
+
 func (m model) textInputUpdate(msg tea.Msg) (ti textinput.Model, cmd tea.Cmd) {
 	ti, cmd = m.textInput.Update(msg)
 	return ti, tea.Batch(newErrMsg(m.textInput.Err), cmd)
@@ -308,9 +647,3 @@ func newErrMsg(err error) tea.Cmd {
 		return errMsg(err)
 	}
 }
-
-func newEndOfStream() tea.Cmd {
-	return func() tea.Msg {
-		return endOfStream{}
-	}
-}
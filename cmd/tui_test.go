@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/meddion/llame"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestModelKeys(t *testing.T) {
+	cfg := llame.DefaultConfig()
+	llm := llame.NewLlamaCppModel(cfg.Endpoint, cfg.Timeout)
+
+	// The model responded with the message, which the user hasn't edited yet.
+	newModel := func() model {
+		m := initialModel(context.Background(), llm, llame.CompletionQuery{}, llame.CompletionQuery{}, false,
+			&cfg, llame.TicketRefs{}, llame.CommitOptions{}, &llame.CommitPlan{}, nil)
+		next, _ := m.Update(streamResp{msg: "Add parser"})
+		next, _ = next.Update(endOfStream{})
+		return next.(model)
+	}
+	update := func(m model, keys string) (model, tea.Cmd) {
+		next, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(keys)})
+		return next.(model), cmd
+	}
+
+	t.Run("help", func(t *testing.T) {
+		m, _ := update(newModel(), "?")
+		assert.True(t, m.help.ShowAll, "? toggles the full help")
+		assert.Contains(t, m.View(), "abort", "the full help lists every binding")
+		assert.Equal(t, "Add parser", m.inputValue())
+
+		m, _ = update(m, "?")
+		assert.False(t, m.help.ShowAll)
+	})
+
+	t.Run("quit", func(t *testing.T) {
+		_, cmd := update(newModel(), "q")
+		require.NotNil(t, cmd)
+		assert.IsType(t, tea.QuitMsg{}, cmd())
+	})
+
+	t.Run("composing", func(t *testing.T) {
+		m, _ := update(newModel(), "!")
+		m, _ = update(m, "?")
+		m, _ = update(m, "q")
+		assert.False(t, m.help.ShowAll)
+		assert.Equal(t, "Add parser!?q", m.inputValue(), "bound keys are typed once the message is edited")
+
+		next, cmd := m.Update(tea.KeyMsg{Type: tea.KeyCtrlC})
+		require.NotNil(t, cmd)
+		assert.IsType(t, tea.QuitMsg{}, cmd())
+		assert.Equal(t, "Add parser!?q", next.(model).inputValue())
+	})
+}
@@ -0,0 +1,282 @@
+package llame
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// SignMode selects whether commits are signed.
+type SignMode string
+
+const (
+	SignAuto   SignMode = "auto" // Follow commit.gpgsign of git config.
+	SignAlways SignMode = "always"
+	SignNever  SignMode = "never"
+)
+
+// CommitOptions configure GitCommit.
+type CommitOptions struct {
+	NoVerify   bool      // Skip the pre-commit and commit-msg hooks, like `git commit --no-verify`.
+	SignOff    bool      // Add a Signed-off-by trailer of the committer.
+	Sign       SignMode  // Empty is the same as SignAuto.
+	Author     string    // "Name <email>" overriding the author from git config.
+	Amend      bool      // Replace the HEAD commit.
+	AllowEmpty bool      // Allow a commit without changes.
+	HookOutput io.Writer // Output of the hooks, discarded if nil.
+}
+
+// Identity is the name and email of an author or a committer.
+type Identity struct {
+	Name  string
+	Email string
+}
+
+func (i Identity) String() string {
+	return fmt.Sprintf("%s <%s>", i.Name, i.Email)
+}
+
+// ParseIdentity parses an identity in the "Name <email>" format.
+func ParseIdentity(s string) (Identity, error) {
+	addr, err := mail.ParseAddress(s)
+	if err != nil || addr.Name == "" {
+		return Identity{}, fmt.Errorf("%q isn't in the 'Name <email>' format", s)
+	}
+
+	return Identity{Name: addr.Name, Email: addr.Address}, nil
+}
+
+// GitIdentity returns the identity of the given role ("author" or "committer") the way git does:
+// from GIT_AUTHOR_NAME (GIT_COMMITTER_NAME) and similar env variables, then author.name
+// (committer.name) and user.name options of git config.
+func GitIdentity(repo *git.Repository, role string) (Identity, error) {
+	lookup := func(field string) string {
+		if value := os.Getenv("GIT_" + strings.ToUpper(role) + "_" + strings.ToUpper(field)); value != "" {
+			return value
+		}
+		if value, ok := GitConfigOption(repo, role, field); ok && value != "" {
+			return value
+		}
+		value, _ := GitConfigOption(repo, "user", field)
+		return value
+	}
+
+	ident := Identity{Name: lookup("name"), Email: lookup("email")}
+	if ident.Name == "" || ident.Email == "" {
+		return Identity{}, fmt.Errorf("%s identity unknown: set user.name and user.email in git config", role)
+	}
+
+	return ident, nil
+}
+
+// CommitPlan describes the commit GitCommit makes with the options, resolved against git config.
+type CommitPlan struct {
+	Author     Identity
+	AuthorWhen time.Time // Zero unless the author of the amended commit is kept.
+	Committer  Identity
+	Signing    *Signing // Nil if the commit isn't signed.
+	SignOff    bool
+	Amend      bool
+	AllowEmpty bool
+}
+
+// PlanCommit resolves the identities and signing settings for a commit with the options.
+func PlanCommit(repo *git.Repository, opts CommitOptions) (*CommitPlan, error) {
+	committer, err := GitIdentity(repo, "committer")
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &CommitPlan{
+		Committer:  committer,
+		SignOff:    opts.SignOff,
+		Amend:      opts.Amend,
+		AllowEmpty: opts.AllowEmpty,
+	}
+
+	switch {
+	case opts.Author != "":
+		if plan.Author, err = ParseIdentity(opts.Author); err != nil {
+			return nil, fmt.Errorf("invalid author: %w", err)
+		}
+	case opts.Amend:
+		// Like git, keep the authorship of the amended commit.
+		head, err := headCommit(repo)
+		if err != nil {
+			return nil, fmt.Errorf("nothing to amend: %w", err)
+		}
+		plan.Author = Identity{Name: head.Author.Name, Email: head.Author.Email}
+		plan.AuthorWhen = head.Author.When
+	default:
+		if plan.Author, err = GitIdentity(repo, "author"); err != nil {
+			return nil, err
+		}
+	}
+
+	sign := opts.Sign
+	if sign == "" || sign == SignAuto {
+		sign = SignNever
+		if value, ok := GitConfigOption(repo, "commit", "gpgSign"); ok && gitBool(value) {
+			sign = SignAlways
+		}
+	}
+	if sign == SignAlways {
+		if plan.Signing, err = GitSigning(repo, committer); err != nil {
+			return nil, err
+		}
+	}
+
+	return plan, nil
+}
+
+func (p *CommitPlan) String() string {
+	parts := []string{"author: " + p.Author.String()}
+	if p.Committer != p.Author {
+		parts = append(parts, "committer: "+p.Committer.String())
+	}
+	if p.Signing != nil {
+		parts = append(parts, "signed ("+string(p.Signing.Format)+")")
+	}
+	if p.SignOff {
+		parts = append(parts, "signed-off")
+	}
+	if p.Amend {
+		parts = append(parts, "amend")
+	}
+	if p.AllowEmpty {
+		parts = append(parts, "allow empty")
+	}
+
+	return strings.Join(parts, " | ")
+}
+
+// gitOptions converts the plan to options of go-git.
+func (p *CommitPlan) gitOptions() *git.CommitOptions {
+	now := time.Now()
+
+	authorWhen := p.AuthorWhen
+	if authorWhen.IsZero() {
+		authorWhen = now
+	}
+
+	opts := &git.CommitOptions{
+		Author:            &object.Signature{Name: p.Author.Name, Email: p.Author.Email, When: authorWhen},
+		Committer:         &object.Signature{Name: p.Committer.Name, Email: p.Committer.Email, When: now},
+		Amend:             p.Amend,
+		AllowEmptyCommits: p.AllowEmpty,
+	}
+	if p.Signing != nil {
+		opts.Signer = p.Signing
+	}
+
+	return opts
+}
+
+var NothingToCommitErr = errors.New("nothing to commit")
+
+// checkNotEmpty fails if nothing is staged, since go-git only refuses to commit an empty index.
+func (p *CommitPlan) checkNotEmpty(workTree *git.Worktree) error {
+	if p.AllowEmpty || p.Amend {
+		return nil
+	}
+
+	status, err := workTree.Status()
+	if err != nil {
+		return err
+	}
+
+	for _, st := range status {
+		if st.Staging != git.Unmodified && st.Staging != git.Untracked {
+			return nil
+		}
+	}
+
+	return NothingToCommitErr
+}
+
+func headCommit(repo *git.Repository) (*object.Commit, error) {
+	head, err := repo.Head()
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.CommitObject(head.Hash())
+}
+
+// HeadCommitMsg returns the message of the HEAD commit.
+func HeadCommitMsg(repo *git.Repository) (string, error) {
+	head, err := headCommit(repo)
+	if err != nil {
+		return "", err
+	}
+
+	return head.Message, nil
+}
+
+// AmendBase returns the revision the amended HEAD commit is made on top of, which
+// is the empty tree for the root commit.
+func AmendBase(repo *git.Repository) (string, error) {
+	head, err := headCommit(repo)
+	if err != nil {
+		return "", fmt.Errorf("nothing to amend: %w", err)
+	}
+
+	if head.NumParents() == 0 {
+		return EmptyTreeHash, nil
+	}
+
+	return head.ParentHashes[0].String(), nil
+}
+
+var trailerRe = regexp.MustCompile(`^[A-Za-z0-9-]+: `)
+
+// AddSignOff appends a Signed-off-by trailer of the identity to the message. The trailer joins
+// the existing trailers and isn't added twice in a row.
+func AddSignOff(msg string, ident Identity) string {
+	return addTrailer(msg, "Signed-off-by: "+ident.String())
+}
+
+// addTrailer appends the trailer to the last paragraph of the message if it only has trailers,
+// or as a new paragraph otherwise.
+func addTrailer(msg, trailer string) string {
+	msg = strings.TrimRightFunc(msg, func(r rune) bool { return r == '\n' || r == ' ' })
+	lines := strings.Split(msg, "\n")
+	if lines[len(lines)-1] == trailer {
+		return msg + "\n"
+	}
+
+	// Trailers are the last paragraph of a message with a body.
+	start := len(lines)
+	for start > 0 && strings.TrimSpace(lines[start-1]) != "" {
+		start--
+	}
+
+	hasTrailers := start > 0
+	for _, line := range lines[start:] {
+		hasTrailers = hasTrailers && trailerRe.MatchString(line)
+	}
+
+	if hasTrailers {
+		return msg + "\n" + trailer + "\n"
+	}
+
+	return msg + "\n\n" + trailer + "\n"
+}
+
+// gitBool parses a boolean value of git config.
+func gitBool(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "yes", "on", "1":
+		return true
+	default:
+		return false
+	}
+}
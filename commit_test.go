@@ -0,0 +1,216 @@
+package llame
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// initTestRepo creates a repository with a configured identity and makes it the working directory.
+// The global git config of the user is hidden, so it doesn't affect tests.
+func initTestRepo(t *testing.T) (*git.Repository, string) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+
+	cfg, err := repo.Config()
+	require.NoError(t, err)
+	cfg.User.Name, cfg.User.Email = "llame", "llame@example.com"
+	require.NoError(t, repo.SetConfig(cfg))
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	return repo, dir
+}
+
+func stageFile(t *testing.T, repo *git.Repository, dir, name, content string) {
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+
+	workTree, err := repo.Worktree()
+	require.NoError(t, err)
+	_, err = workTree.Add(name)
+	require.NoError(t, err)
+}
+
+func setGitOption(t *testing.T, repo *git.Repository, section, subsection, key, value string) {
+	cfg, err := repo.Config()
+	require.NoError(t, err)
+
+	s := cfg.Raw.Section(section)
+	if subsection != "" {
+		s.Subsection(subsection).SetOption(key, value)
+	} else {
+		s.SetOption(key, value)
+	}
+	require.NoError(t, repo.SetConfig(cfg))
+}
+
+func headCommitT(t *testing.T, repo *git.Repository) *object.Commit {
+	commit, err := headCommit(repo)
+	require.NoError(t, err)
+	return commit
+}
+
+func TestAddSignOff(t *testing.T) {
+	ident := Identity{Name: "Jane Doe", Email: "jane@example.com"}
+	trailer := "Signed-off-by: Jane Doe <jane@example.com>\n"
+
+	for _, tc := range []struct{ msg, want string }{
+		{msg: "Fix: typo", want: "Fix: typo\n\n" + trailer},
+		{msg: "Subject\n\nBody.\n", want: "Subject\n\nBody.\n\n" + trailer},
+		{msg: "Subject\n\nRefs: #1\n", want: "Subject\n\nRefs: #1\n" + trailer},
+		{msg: "Subject\n\n" + trailer, want: "Subject\n\n" + trailer},
+	} {
+		assert.Equal(t, tc.want, AddSignOff(tc.msg, ident), tc.msg)
+	}
+}
+
+func TestParseIdentity(t *testing.T) {
+	ident, err := ParseIdentity("Jane Doe <jane@example.com>")
+	require.NoError(t, err)
+	assert.Equal(t, Identity{Name: "Jane Doe", Email: "jane@example.com"}, ident)
+
+	for _, s := range []string{"jane@example.com", "Jane Doe", "<jane@example.com>"} {
+		_, err := ParseIdentity(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestPlanCommit(t *testing.T) {
+	repo, dir := initTestRepo(t)
+
+	plan, err := PlanCommit(repo, CommitOptions{})
+	require.NoError(t, err)
+	llame := Identity{Name: "llame", Email: "llame@example.com"}
+	assert.Equal(t, llame, plan.Author)
+	assert.Equal(t, llame, plan.Committer)
+	assert.Nil(t, plan.Signing)
+
+	t.Setenv("GIT_AUTHOR_NAME", "Env Author")
+	plan, err = PlanCommit(repo, CommitOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, Identity{Name: "Env Author", Email: "llame@example.com"}, plan.Author)
+	assert.Equal(t, llame, plan.Committer)
+
+	plan, err = PlanCommit(repo, CommitOptions{Author: "Jane Doe <jane@example.com>"})
+	require.NoError(t, err)
+	assert.Equal(t, Identity{Name: "Jane Doe", Email: "jane@example.com"}, plan.Author)
+
+	_, err = PlanCommit(repo, CommitOptions{Amend: true})
+	assert.ErrorContains(t, err, "nothing to amend")
+
+	t.Run("signing", func(t *testing.T) {
+		setGitOption(t, repo, "commit", "", "gpgSign", "true")
+		setGitOption(t, repo, "gpg", "", "format", "ssh")
+
+		_, err := PlanCommit(repo, CommitOptions{})
+		assert.ErrorContains(t, err, "user.signingkey")
+
+		plan, err := PlanCommit(repo, CommitOptions{Sign: SignNever})
+		require.NoError(t, err)
+		assert.Nil(t, plan.Signing)
+
+		setGitOption(t, repo, "user", "", "signingKey", "~/.ssh/id_ed25519.pub")
+		setGitOption(t, repo, "gpg", "ssh", "program", "/opt/ssh-keygen")
+		plan, err = PlanCommit(repo, CommitOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, &Signing{Format: SignFormatSSH, Program: "/opt/ssh-keygen", Key: "~/.ssh/id_ed25519.pub"}, plan.Signing)
+
+		setGitOption(t, repo, "commit", "", "gpgSign", "false")
+		setGitOption(t, repo, "gpg", "", "format", "openpgp")
+		setGitOption(t, repo, "user", "", "signingKey", "")
+		plan, err = PlanCommit(repo, CommitOptions{Sign: SignAlways})
+		require.NoError(t, err)
+		assert.Equal(t, &Signing{Format: SignFormatOpenPGP, Program: "gpg", Key: "llame <llame@example.com>"}, plan.Signing)
+		assert.Equal(t, "author: Env Author <llame@example.com> | committer: llame <llame@example.com> | signed (openpgp)", plan.String())
+	})
+
+	t.Run("amend keeps the author", func(t *testing.T) {
+		stageFile(t, repo, dir, "file", "content\n")
+		require.NoError(t, GitCommit(context.Background(), "Add file", CommitOptions{Sign: SignNever}))
+		when := headCommitT(t, repo).Author.When
+
+		t.Setenv("GIT_AUTHOR_NAME", "Someone Else")
+		plan, err := PlanCommit(repo, CommitOptions{Amend: true})
+		require.NoError(t, err)
+		assert.Equal(t, Identity{Name: "Env Author", Email: "llame@example.com"}, plan.Author)
+		assert.True(t, when.Equal(plan.AuthorWhen))
+	})
+}
+
+func TestGitCommitOptions(t *testing.T) {
+	repo, dir := initTestRepo(t)
+	ctx := context.Background()
+
+	err := GitCommit(ctx, "Nothing", CommitOptions{})
+	assert.ErrorIs(t, err, NothingToCommitErr)
+
+	stageFile(t, repo, dir, "file", "content\n")
+	require.NoError(t, GitCommit(ctx, "Add file", CommitOptions{SignOff: true}))
+	first := headCommitT(t, repo)
+	assert.Equal(t, "Add file\n\nSigned-off-by: llame <llame@example.com>\n", first.Message)
+
+	assert.ErrorIs(t, GitCommit(ctx, "Nothing", CommitOptions{}), NothingToCommitErr)
+	require.NoError(t, GitCommit(ctx, "Empty", CommitOptions{AllowEmpty: true}))
+	empty := headCommitT(t, repo)
+	assert.Equal(t, first.TreeHash, empty.TreeHash)
+
+	base, err := AmendBase(repo)
+	require.NoError(t, err)
+	assert.Equal(t, first.Hash.String(), base)
+
+	stageFile(t, repo, dir, "file", "changed\n")
+	require.NoError(t, GitCommit(ctx, "Change file", CommitOptions{Amend: true, Author: "Jane Doe <jane@example.com>"}))
+	amended := headCommitT(t, repo)
+	assert.Equal(t, []plumbing.Hash{first.Hash}, amended.ParentHashes)
+	assert.Equal(t, "Jane Doe", amended.Author.Name)
+	assert.Equal(t, "llame", amended.Committer.Name)
+}
+
+func TestGitCommitSSHSigning(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen isn't installed")
+	}
+
+	repo, dir := initTestRepo(t)
+
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", "llame", "-f", keyPath).CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	pubKey, err := os.ReadFile(keyPath + ".pub")
+	require.NoError(t, err)
+	allowedSigners := filepath.Join(t.TempDir(), "allowed_signers")
+	require.NoError(t, os.WriteFile(allowedSigners, append([]byte("llame@example.com "), pubKey...), 0o644))
+
+	setGitOption(t, repo, "gpg", "", "format", "ssh")
+	setGitOption(t, repo, "user", "", "signingKey", keyPath)
+
+	stageFile(t, repo, dir, "file", "content\n")
+	require.NoError(t, GitCommit(context.Background(), "Add file", CommitOptions{Sign: SignAlways}))
+
+	commit := headCommitT(t, repo)
+	assert.Contains(t, commit.PGPSignature, "-----BEGIN SSH SIGNATURE-----")
+	assert.WithinDuration(t, time.Now(), commit.Committer.When, time.Minute)
+
+	if _, err := exec.LookPath("git"); err != nil {
+		return
+	}
+	out, err = exec.Command("git", "-c", "gpg.ssh.allowedSignersFile="+allowedSigners, "verify-commit", "HEAD").CombinedOutput()
+	assert.NoError(t, err, string(out))
+}
@@ -0,0 +1,1163 @@
+package llame
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+const (
+	ConfigDirName      = "llame"
+	ConfigFileName     = "config.toml"
+	RepoConfigFileName = ".llame.toml"
+
+	// RepoPromptFormatsDir holds the prompt formats of the repository, see LoadPromptFormats.
+	RepoPromptFormatsDir = ".llame/" + PromptFormatsDirName
+)
+
+// Config is merged from the following layers, where every next one takes precedence:
+// defaults, user config file, repository config file, the selected profile,
+// env variables and CLI flags.
+type Config struct {
+	Profile     string        `toml:"profile"` // Name of the profile to apply
+	Endpoint    string        `toml:"endpoint"`
+	Backend     string        `toml:"backend"`
+	Timeout     time.Duration `toml:"timeout"`
+	ModelType   string        `toml:"model_type"`
+	PromptStyle PromptStyle   `toml:"prompt_style"`
+	// TrustedRepos are the roots of the repositories (or their glob patterns, e.g. "~/work/*") whose
+	// config may set the keys guarded from cloned repositories, see RepoTrusted. User config only.
+	TrustedRepos []string       `toml:"trusted_repos"`
+	Sampling     SamplingConfig `toml:"sampling"`
+	Body         BodyConfig     `toml:"body"`
+	Prompt       PromptConfig   `toml:"prompt"`
+
+	Diff         DiffConfig         `toml:"diff"`
+	Commit       CommitConfig       `toml:"commit"`
+	Conventional ConventionalConfig `toml:"conventional"`
+	History      HistoryConfig      `toml:"history"`
+	Ticket       TicketConfig       `toml:"ticket"`
+	Redact       RedactConfig       `toml:"redact"`
+
+	Keys  KeysConfig  `toml:"keys"`
+	Theme ThemeConfig `toml:"theme"`
+
+	// Sources holds the layer every value comes from, keyed by the dotted TOML key.
+	Sources map[string]ConfigSource `toml:"-"`
+	// PromptFormats are the built-in formats merged with the ones of the config directories.
+	PromptFormats PromptFormats `toml:"-"`
+	// Warnings are the keys of the repository config that were ignored, see RepoTrusted.
+	Warnings []error `toml:"-"`
+
+	profiles    map[string][]profileDef
+	repoRoot    string
+	repoTrusted bool // Set before the repository config is decoded
+}
+
+const BackendLlamaCpp = "llama.cpp"
+
+type PromptStyle string
+
+const (
+	PromptStylePlain        PromptStyle = "plain"        // A concise subject line
+	PromptStyleConventional PromptStyle = "conventional" // A Conventional Commits subject line
+)
+
+// BodyFormat is how the body of a generated commit message explains the changes.
+type BodyFormat string
+
+const (
+	BodyFormatProse   BodyFormat = "prose"   // Short paragraphs
+	BodyFormatBullets BodyFormat = "bullets" // A list of "- " items
+)
+
+// SamplingConfig holds generation parameters sent to the model server.
+type SamplingConfig struct {
+	Temperature float64 `toml:"temperature"`
+	TopK        int     `toml:"top_k"`
+	TopP        float64 `toml:"top_p"`
+	NPredict    int     `toml:"n_predict"`
+}
+
+// BodyConfig configures generating full commit messages, with a body explaining
+// what changed and why, instead of subjects only.
+type BodyConfig struct {
+	Enabled  bool       `toml:"enabled"` // Overridden by --body and --no-body
+	Format   BodyFormat `toml:"format"`
+	NPredict int        `toml:"n_predict"` // Used instead of sampling.n_predict
+}
+
+// PromptConfig points to text/template files overriding the built-in instructions given to
+// the model, see PromptData for the variables. Relative paths are resolved against the directory
+// of the config file they're set in.
+type PromptConfig struct {
+	Template     string `toml:"template"`      // Of subjects
+	BodyTemplate string `toml:"body_template"` // Of full messages
+}
+
+// PromptTemplates loads the instruction templates set in config.
+func (c Config) PromptTemplates() (*PromptTemplates, error) {
+	return LoadPromptTemplates(c.configPath("prompt.template", c.Prompt.Template),
+		c.configPath("prompt.body_template", c.Prompt.BodyTemplate))
+}
+
+// configPath resolves a relative path set by the key against the directory of its config file.
+func (c Config) configPath(key, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+
+	// Profiles are set in the config files too.
+	switch source := c.Sources[key]; source.Layer {
+	case LayerUser, LayerRepo:
+		return filepath.Join(filepath.Dir(source.Name), path)
+	}
+
+	return path
+}
+
+// DiffConfig configures the diff of the staged changes given to the model, see DiffOptions.
+type DiffConfig struct {
+	Engine       DiffEngine `toml:"engine"`
+	ContextLines int        `toml:"context_lines"`
+	Renames      bool       `toml:"renames"`
+	Include      []string   `toml:"include"`
+	Exclude      []string   `toml:"exclude"`
+	Attributes   bool       `toml:"attributes"`
+}
+
+// Options returns the diff options set in config.
+func (c DiffConfig) Options() DiffOptions {
+	return DiffOptions{
+		Engine:        c.Engine,
+		ContextLines:  c.ContextLines,
+		DetectRenames: c.Renames,
+		Include:       c.Include,
+		Exclude:       c.Exclude,
+		Attributes:    c.Attributes,
+	}
+}
+
+// CommitConfig holds the default commit options, see CommitOptions.
+type CommitConfig struct {
+	SignOff    bool     `toml:"signoff"`
+	Sign       SignMode `toml:"sign"`
+	Author     string   `toml:"author"` // "Name <email>", empty to take it from git config
+	AllowEmpty bool     `toml:"allow_empty"`
+}
+
+// Options returns the commit options set in config.
+func (c CommitConfig) Options() CommitOptions {
+	return CommitOptions{
+		SignOff:    c.SignOff,
+		Sign:       c.Sign,
+		Author:     c.Author,
+		AllowEmpty: c.AllowEmpty,
+	}
+}
+
+// ConventionalConfig restricts Conventional Commits messages of the "conventional" prompt style.
+type ConventionalConfig struct {
+	Types  []string `toml:"types"`
+	Scopes []string `toml:"scopes"` // Any scope is allowed if empty
+	// ScopeMap maps .gitignore patterns of paths to their scopes, the scope of other paths is
+	// their top-level directory.
+	ScopeMap map[string]string `toml:"scope_map"`
+}
+
+// HistoryConfig configures learning the commit style from past commits, see HistoryOptions.
+type HistoryConfig struct {
+	Enabled  bool     `toml:"enabled"`
+	Depth    int      `toml:"depth"`    // Commits to learn from
+	Examples int      `toml:"examples"` // Past messages given as examples, besides the pinned ones
+	Pin      []string `toml:"pin"`      // Revisions of commits always given as examples
+	Exclude  []string `toml:"exclude"`  // Revisions of commits never given as examples
+}
+
+// TicketConfig configures referencing the tickets of the branch in commit messages, see TicketOptions.
+type TicketConfig struct {
+	Patterns  []string        `toml:"patterns"`  // Regexps of ticket IDs in branch names
+	Placement TicketPlacement `toml:"placement"` // "none", "prefix", "scope" or "trailer"
+	Trailer   string          `toml:"trailer"`   // Key of the trailer, e.g. "Refs"
+}
+
+// RedactConfig configures redacting secrets in the staged changes before they're sent to the
+// model, see RedactOptions.
+type RedactConfig struct {
+	Enabled  bool       `toml:"enabled"`
+	Mode     RedactMode `toml:"mode"`     // "redact" or "refuse"
+	Patterns []string   `toml:"patterns"` // Regexps of secrets, besides the built-in rules
+	Entropy  bool       `toml:"entropy"`  // Redact random looking strings
+}
+
+// Options returns the redaction options set in config.
+func (c RedactConfig) Options() RedactOptions {
+	return RedactOptions{
+		Patterns: c.Patterns,
+		Entropy:  c.Entropy,
+	}
+}
+
+// KeysConfig maps TUI actions to the keys triggering them.
+// Key names follow bubbletea's notation, e.g. "enter", "ctrl+r", "esc".
+type KeysConfig struct {
+	Commit  []string `toml:"commit"`
+	Regen   []string `toml:"regenerate"`
+	Abort   []string `toml:"abort"`
+	Body    []string `toml:"body"`    // Switches between subjects and full messages
+	Newline []string `toml:"newline"` // In full messages
+	Help    []string `toml:"help"`
+	Quit    []string `toml:"quit"`
+}
+
+// ThemeConfig holds TUI colors, either ANSI (e.g. "250") or hex (e.g. "#ff0000") ones.
+type ThemeConfig struct {
+	Text    string `toml:"text"`
+	Error   string `toml:"error"`
+	NoColor bool   `toml:"no_color"`
+}
+
+// ColorDisabled reports whether colors are turned off in config or
+// with the NO_COLOR env variable (https://no-color.org).
+func (t ThemeConfig) ColorDisabled() bool {
+	return t.NoColor || os.Getenv("NO_COLOR") != ""
+}
+
+type ConfigLayer string
+
+const (
+	LayerDefault ConfigLayer = "default"
+	LayerUser    ConfigLayer = "user config"
+	LayerRepo    ConfigLayer = "repo config"
+	LayerProfile ConfigLayer = "profile"
+	LayerEnv     ConfigLayer = "env"
+	LayerFlag    ConfigLayer = "flag"
+)
+
+// ConfigSource tells where a config value is set.
+type ConfigSource struct {
+	Layer   ConfigLayer
+	Name    string // File path, env variable or flag name
+	Line    int    // Line in the file, if known
+	Profile string // Profile the value is set by
+}
+
+func (s ConfigSource) String() string {
+	if s.Profile != "" {
+		file := s
+		file.Profile = ""
+		return fmt.Sprintf("%s %q in %s", LayerProfile, s.Profile, file)
+	}
+
+	switch {
+	case s.Name == "":
+		return string(s.Layer)
+	case s.Line > 0:
+		return fmt.Sprintf("%s %s:%d", s.Layer, s.Name, s.Line)
+	default:
+		return fmt.Sprintf("%s %s", s.Layer, s.Name)
+	}
+}
+
+// ConfigEnvVars maps env variables to the config keys they set.
+var ConfigEnvVars = map[string]string{
+	"LLAME_PROFILE":    "profile",
+	"MODEL_ENDPOINT":   "endpoint",
+	"LLAME_TIMEOUT":    "timeout",
+	"LLAME_MODEL_TYPE": "model_type",
+}
+
+func DefaultConfig() Config {
+	cfg := Config{
+		Endpoint:    "http://127.0.0.1:8080/completion",
+		Backend:     BackendLlamaCpp,
+		Timeout:     15 * time.Second,
+		ModelType:   "mistral",
+		PromptStyle: PromptStylePlain,
+		Sampling: SamplingConfig{
+			Temperature: 0.5,
+			NPredict:    512,
+		},
+		Body: BodyConfig{
+			Format:   BodyFormatProse,
+			NPredict: 1024,
+		},
+		Diff: DiffConfig{
+			Engine:       DiffEngineNative,
+			ContextLines: 3,
+			Renames:      true,
+			Exclude:      slices.Clone(DefaultDiffExclude),
+			Attributes:   true,
+		},
+		Commit: CommitConfig{
+			Sign: SignAuto,
+		},
+		Conventional: ConventionalConfig{
+			Types: slices.Clone(DefaultConventionalTypes),
+		},
+		History: HistoryConfig{
+			Enabled:  true,
+			Depth:    100,
+			Examples: 3,
+		},
+		Ticket: TicketConfig{
+			Patterns:  slices.Clone(DefaultTicketPatterns),
+			Placement: TicketNone,
+			Trailer:   "Refs",
+		},
+		Redact: RedactConfig{
+			Enabled: true,
+			Mode:    RedactModeRedact,
+			Entropy: true,
+		},
+		Keys: KeysConfig{
+			Commit:  []string{"enter"},
+			Regen:   []string{"ctrl+r"},
+			Abort:   []string{"esc"},
+			Body:    []string{"ctrl+o"},
+			Newline: []string{"alt+enter", "ctrl+j"},
+			Help:    []string{"?"},
+			Quit:    []string{"q", "ctrl+c"},
+		},
+		Theme: ThemeConfig{
+			Text:  "250",
+			Error: "196",
+		},
+		Sources:       make(map[string]ConfigSource),
+		PromptFormats: GetPromptFormats(),
+	}
+
+	for key := range cfg.fields() {
+		cfg.Sources[key] = ConfigSource{Layer: LayerDefault}
+	}
+
+	return cfg
+}
+
+// UserConfigPath returns the path of the user-level config file,
+// which is $XDG_CONFIG_HOME/llame/config.toml or ~/.config/llame/config.toml.
+func UserConfigPath() (string, error) {
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+	if configDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		configDir = filepath.Join(home, ".config")
+	}
+
+	return filepath.Join(configDir, ConfigDirName, ConfigFileName), nil
+}
+
+// ConfigOverride sets a config key from a CLI flag (or an env variable).
+type ConfigOverride struct {
+	Key   string
+	Flag  string
+	Env   string
+	Value string
+}
+
+func (o ConfigOverride) source() ConfigSource {
+	if o.Env != "" {
+		return ConfigSource{Layer: LayerEnv, Name: o.Env}
+	}
+
+	return ConfigSource{Layer: LayerFlag, Name: o.Flag}
+}
+
+// LoadConfig merges the user config file at userPath and the repository config file
+// (if repoRoot isn't empty) over the defaults, then applies env variables and flags,
+// and validates the result. Missing files are skipped. Prompt formats are loaded from
+// the prompt-formats directory next to the user config file and RepoPromptFormatsDir.
+func LoadConfig(userPath, repoRoot string, flags ...ConfigOverride) (Config, error) {
+	cfg := DefaultConfig()
+
+	layers := []struct {
+		layer ConfigLayer
+		path  string
+	}{
+		{LayerUser, userPath},
+	}
+	var formatDirs []string
+	if userPath != "" {
+		formatDirs = append(formatDirs, filepath.Join(filepath.Dir(userPath), PromptFormatsDirName))
+	}
+	if repoRoot != "" {
+		layers = append(layers, struct {
+			layer ConfigLayer
+			path  string
+		}{LayerRepo, filepath.Join(repoRoot, RepoConfigFileName)})
+		formatDirs = append(formatDirs, filepath.Join(repoRoot, RepoPromptFormatsDir))
+	}
+
+	for _, l := range layers {
+		if l.path == "" {
+			continue
+		}
+
+		if err := cfg.decodeFile(l.layer, l.path); err != nil {
+			return cfg, err
+		}
+	}
+
+	formats, err := LoadPromptFormats(formatDirs...)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.PromptFormats = formats
+
+	// Env variables and flags take precedence over profiles, but they may select one as well.
+	overrides := make([]ConfigOverride, 0, len(ConfigEnvVars)+len(flags))
+	for env, key := range ConfigEnvVars {
+		if value, ok := os.LookupEnv(env); ok && value != "" {
+			overrides = append(overrides, ConfigOverride{Key: key, Value: value, Env: env})
+		}
+	}
+	overrides = append(overrides, flags...)
+
+	for _, override := range overrides {
+		if override.Key == "profile" {
+			if err := cfg.Set(override.Key, override.Value, override.source()); err != nil {
+				return cfg, err
+			}
+		}
+	}
+
+	if err := cfg.applyProfile(); err != nil {
+		return cfg, err
+	}
+
+	for _, override := range overrides {
+		if err := cfg.Set(override.Key, override.Value, override.source()); err != nil {
+			return cfg, err
+		}
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// ConfigError points to the place in a config file where the error occurred.
+type ConfigError struct {
+	Source ConfigSource
+	Key    string
+	Err    error
+}
+
+func (e *ConfigError) Error() string {
+	if e.Key == "" {
+		return fmt.Sprintf("%s: %s", e.Source, e.Err)
+	}
+
+	return fmt.Sprintf("%s: %s: %s", e.Source, e.Key, e.Err)
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// decodeFile decodes the file on top of the config, so only the keys present in it are overwritten.
+func (c *Config) decodeFile(layer ConfigLayer, path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return fmt.Errorf("reading config %s: %w", path, err)
+	}
+
+	Debugf("Loading %s from %s", layer, path)
+	if layer == LayerRepo {
+		c.repoRoot = filepath.Dir(path)
+		c.repoTrusted = c.RepoTrusted(c.repoRoot)
+	}
+
+	// The values the repository config can't set are restored after decoding.
+	var prev map[string]reflect.Value
+	if layer == LayerRepo {
+		prev = c.snapshot()
+	}
+
+	// Profiles are decoded when (and if) they're selected.
+	file := struct {
+		*Config
+		Profiles map[string]toml.Primitive `toml:"profiles"`
+	}{Config: c}
+
+	md, err := toml.NewDecoder(bytes.NewReader(content)).Decode(&file)
+	if err != nil {
+		source := ConfigSource{Layer: layer, Name: path}
+
+		var parseErr toml.ParseError
+		if errors.As(err, &parseErr) {
+			source.Line = parseErr.Position.Line
+			if parseErr.Message != "" {
+				err = errors.New(parseErr.Message)
+			}
+		}
+
+		return &ConfigError{Source: source, Err: err}
+	}
+
+	lines := keyLines(content)
+	source := func(key string) ConfigSource {
+		// Keys of inline tables point to the line of the table.
+		for {
+			if line, ok := lines[key]; ok {
+				return ConfigSource{Layer: layer, Name: path, Line: line}
+			}
+
+			i := strings.LastIndex(key, ".")
+			if i == -1 {
+				return ConfigSource{Layer: layer, Name: path}
+			}
+			key = key[:i]
+		}
+	}
+
+	// MetaData.Keys isn't reliable for nested tables, so the keys are collected separately.
+	var raw map[string]any
+	if _, err := toml.Decode(string(content), &raw); err != nil {
+		return &ConfigError{Source: ConfigSource{Layer: layer, Name: path}, Err: err}
+	}
+	keys := flattenKeys(raw, "")
+	slices.SortFunc(keys, func(a, b string) int {
+		return cmp.Or(cmp.Compare(source(a).Line, source(b).Line), cmp.Compare(a, b))
+	})
+
+	fields := c.fields()
+
+	var errs []error
+	for _, key := range keys {
+		if strings.HasPrefix(key, "profiles.") {
+			continue
+		}
+
+		fieldKey, ok := tableKey(fields, key)
+		if !ok {
+			errs = append(errs, &ConfigError{Source: source(key), Key: key, Err: errors.New("unknown key")})
+			continue
+		}
+
+		if layer == LayerRepo {
+			if err := c.checkRepoKey(fieldKey, fields[fieldKey]); err != nil {
+				c.Warnings = append(c.Warnings, &ConfigError{Source: source(key), Key: key, Err: err})
+				fields[fieldKey].Set(prev[fieldKey])
+				continue
+			}
+		}
+
+		c.Sources[fieldKey] = source(fieldKey)
+	}
+
+	if c.profiles == nil {
+		c.profiles = make(map[string][]profileDef)
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(file.Profiles)) {
+		def := profileDef{md: &md, prim: file.Profiles[name], keys: make(map[string]ConfigSource)}
+
+		prefix := "profiles." + name + "."
+		for _, key := range keys {
+			profileKey, ok := strings.CutPrefix(key, prefix)
+			if !ok {
+				continue
+			}
+
+			source := source(key)
+			if _, ok := fields[profileKey]; !ok || !isProfileKey(profileKey) {
+				errs = append(errs, &ConfigError{Source: source, Key: key, Err: errors.New("unknown profile key")})
+				continue
+			}
+			source.Profile = name
+			def.keys[profileKey] = source
+		}
+
+		// Catch type errors early, even if the profile isn't selected.
+		scratch := DefaultConfig()
+		if err := md.PrimitiveDecode(def.prim, &scratch); err != nil {
+			errs = append(errs, &ConfigError{Source: source("profiles." + name), Key: "profiles." + name, Err: err})
+		}
+
+		c.profiles[name] = append(c.profiles[name], def)
+	}
+
+	return errors.Join(errs...)
+}
+
+// RepoTrusted reports whether the repository at root is listed in trusted_repos. The config of
+// an untrusted repository, e.g. a cloned one, can't send the changes to another model server,
+// turn off redaction or read files outside of the repository into the prompt.
+func (c Config) RepoTrusted(root string) bool {
+	if root == "" {
+		return false
+	}
+
+	root = filepath.Clean(root)
+	for _, pattern := range c.TrustedRepos {
+		if rest, ok := strings.CutPrefix(pattern, "~/"); ok {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				continue
+			}
+			pattern = filepath.Join(home, rest)
+		}
+
+		if ok, _ := filepath.Match(filepath.Clean(pattern), root); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkRepoKey returns an error if the repository config can't set the key to the decoded value,
+// see RepoTrusted. Such keys are ignored with a warning rather than failing every command.
+func (c *Config) checkRepoKey(key string, value reflect.Value) error {
+	switch {
+	case key == "trusted_repos":
+		return errors.New("can only be set in the user config")
+	case c.repoTrusted:
+		return nil
+	case key == "endpoint", key == "backend", strings.HasPrefix(key, "redact."):
+		return fmt.Errorf("can only be set in the config of a trusted repository, add %q to trusted_repos of the user config", c.repoRoot)
+	case key == "prompt.template", key == "prompt.body_template":
+		path := value.String()
+		if path == "" {
+			return nil
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(c.repoRoot, path)
+		}
+		if !c.inRepo(path) {
+			return fmt.Errorf("%s is outside of the repository, which isn't in trusted_repos of the user config", path)
+		}
+	}
+
+	return nil
+}
+
+// inRepo reports whether the path is inside the repository, following symlinks.
+func (c Config) inRepo(path string) bool {
+	root := c.repoRoot
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+	// Missing templates are reported by Validate.
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// tableKey returns the key of the field the dotted key sets: the key itself,
+// or the table with arbitrary keys containing it.
+func tableKey(fields map[string]reflect.Value, key string) (string, bool) {
+	if _, ok := fields[key]; ok {
+		return key, true
+	}
+
+	for i := strings.LastIndex(key, "."); i != -1; i = strings.LastIndex(key[:i], ".") {
+		if field, ok := fields[key[:i]]; ok && field.Kind() == reflect.Map {
+			return key[:i], true
+		}
+	}
+
+	return "", false
+}
+
+// flattenKeys returns dotted keys of all the non-table values.
+func flattenKeys(table map[string]any, prefix string) []string {
+	var keys []string
+	for key, value := range table {
+		if subTable, ok := value.(map[string]any); ok {
+			keys = append(keys, flattenKeys(subTable, prefix+key+".")...)
+			continue
+		}
+
+		keys = append(keys, prefix+key)
+	}
+
+	return keys
+}
+
+// profileDef is a profile defined in a single config file.
+type profileDef struct {
+	md   *toml.MetaData
+	prim toml.Primitive
+	keys map[string]ConfigSource // Keys set by the profile
+}
+
+// isProfileKey reports whether the key can be set by a profile.
+func isProfileKey(key string) bool {
+	switch key {
+	case "endpoint", "backend", "timeout", "model_type", "prompt_style":
+		return true
+	}
+
+	return strings.HasPrefix(key, "sampling.") || strings.HasPrefix(key, "prompt.")
+}
+
+// applyProfile decodes the selected profile over the config. Profiles with
+// the same name from several config files are applied in the order of the files.
+func (c *Config) applyProfile() error {
+	if c.Profile == "" {
+		return nil
+	}
+
+	defs, ok := c.profiles[c.Profile]
+	if !ok {
+		return &ConfigError{Source: c.Sources["profile"], Key: "profile", Err: fmt.Errorf("unknown profile %q", c.Profile)}
+	}
+
+	Debugf("Applying profile %q", c.Profile)
+
+	for _, def := range defs {
+		prev, fields := c.snapshot(), c.fields()
+		if err := def.md.PrimitiveDecode(def.prim, c); err != nil {
+			return err
+		}
+
+		for _, key := range slices.Sorted(maps.Keys(def.keys)) {
+			source := def.keys[key]
+			if source.Layer == LayerRepo {
+				if err := c.checkRepoKey(key, fields[key]); err != nil {
+					c.Warnings = append(c.Warnings, &ConfigError{Source: source, Key: key, Err: err})
+					fields[key].Set(prev[key])
+					continue
+				}
+			}
+
+			c.Sources[key] = source
+		}
+	}
+
+	return nil
+}
+
+// ProfileNames returns the sorted names of all defined profiles.
+func (c Config) ProfileNames() []string {
+	return slices.Sorted(maps.Keys(c.profiles))
+}
+
+// keyLines maps dotted keys to the lines they're defined at. Only the keys
+// set with "key = value" under a [table] header are found, which is enough for llame configs.
+func keyLines(content []byte) map[string]int {
+	lines := make(map[string]int)
+
+	var table string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "["):
+			table = strings.Trim(line, "[] ")
+			lines[table] = n
+		case strings.Contains(line, "="):
+			key := strings.Trim(strings.TrimSpace(strings.SplitN(line, "=", 2)[0]), `"`)
+			if table != "" {
+				key = table + "." + key
+			}
+			if _, ok := lines[key]; !ok {
+				lines[key] = n
+			}
+		}
+	}
+
+	return lines
+}
+
+// fields maps dotted TOML keys to the settable scalar (or string list) config fields.
+func (c *Config) fields() map[string]reflect.Value {
+	fields := make(map[string]reflect.Value)
+	walkConfig(reflect.ValueOf(c).Elem(), "", func(key string, field reflect.Value) {
+		fields[key] = field
+	})
+
+	return fields
+}
+
+// snapshot copies the values of all the fields, so they can be restored after decoding over them.
+func (c *Config) snapshot() map[string]reflect.Value {
+	values := make(map[string]reflect.Value)
+	for key, field := range c.fields() {
+		value := reflect.New(field.Type()).Elem()
+		switch {
+		case field.Kind() == reflect.Slice && !field.IsNil():
+			// The decoder reuses the backing arrays of slices.
+			value.Set(reflect.AppendSlice(reflect.MakeSlice(field.Type(), 0, field.Len()), field))
+		case field.Kind() == reflect.Map && !field.IsNil():
+			value.Set(reflect.MakeMapWithSize(field.Type(), field.Len()))
+			for iter := field.MapRange(); iter.Next(); {
+				value.SetMapIndex(iter.Key(), iter.Value())
+			}
+		default:
+			value.Set(field)
+		}
+		values[key] = value
+	}
+
+	return values
+}
+
+// walkConfig calls fn for every non-table field of the struct v in the order of declaration.
+func walkConfig(v reflect.Value, prefix string, fn func(key string, field reflect.Value)) {
+	for i := 0; i < v.NumField(); i++ {
+		tag := v.Type().Field(i).Tag.Get("toml")
+		if tag == "" || tag == "-" {
+			continue
+		}
+
+		key := prefix + tag
+		switch field := v.Field(i); field.Kind() {
+		case reflect.Struct:
+			walkConfig(field, key+".", fn)
+		case reflect.Map:
+			// Tables with arbitrary keys are values of their own.
+			if field.Type().Key().Kind() == reflect.String && field.Type().Elem().Kind() == reflect.String {
+				fn(key, field)
+			}
+		default:
+			fn(key, field)
+		}
+	}
+}
+
+// Set parses the value for the config key. Lists are given as comma separated values,
+// tables as comma separated key=value pairs.
+func (c *Config) Set(key, value string, source ConfigSource) error {
+	field, ok := c.fields()[key]
+	if !ok {
+		return &ConfigError{Source: source, Key: key, Err: errors.New("unknown key")}
+	}
+
+	setErr := func(err error) error {
+		return &ConfigError{Source: source, Key: key, Err: err}
+	}
+
+	switch field.Interface().(type) {
+	case time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return setErr(err)
+		}
+		field.Set(reflect.ValueOf(d))
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return setErr(err)
+		}
+		field.SetBool(b)
+	case int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return setErr(err)
+		}
+		field.SetInt(int64(n))
+	case float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return setErr(err)
+		}
+		field.SetFloat(f)
+	case string, PromptStyle, BodyFormat, DiffEngine, SignMode, TicketPlacement, RedactMode:
+		field.SetString(value)
+	case []string:
+		var list []string
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				list = append(list, v)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+	case map[string]string:
+		table := make(map[string]string)
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v == "" {
+				continue
+			}
+			k, v, ok := strings.Cut(v, "=")
+			if !ok {
+				return setErr(fmt.Errorf("expected key=value, got %q", v))
+			}
+			table[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+		field.Set(reflect.ValueOf(table))
+	default:
+		return setErr(fmt.Errorf("unsupported type %s", field.Type()))
+	}
+
+	c.Sources[key] = source
+
+	return nil
+}
+
+// ConfigEntry is a single effective config value.
+type ConfigEntry struct {
+	Key    string
+	Value  string // TOML encoded value
+	Source ConfigSource
+}
+
+// Entries returns all the config values in the order of the Config fields.
+func (c Config) Entries() []ConfigEntry {
+	var entries []ConfigEntry
+	walkConfig(reflect.ValueOf(c), "", func(key string, field reflect.Value) {
+		entries = append(entries, ConfigEntry{
+			Key:    key,
+			Value:  tomlValue(field.Interface()),
+			Source: c.Sources[key],
+		})
+	})
+
+	return entries
+}
+
+func tomlValue(v any) string {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v)
+	case PromptStyle:
+		return strconv.Quote(string(v))
+	case BodyFormat:
+		return strconv.Quote(string(v))
+	case DiffEngine:
+		return strconv.Quote(string(v))
+	case SignMode:
+		return strconv.Quote(string(v))
+	case TicketPlacement:
+		return strconv.Quote(string(v))
+	case RedactMode:
+		return strconv.Quote(string(v))
+	case time.Duration:
+		return strconv.Quote(v.String())
+	case []string:
+		quoted := make([]string, len(v))
+		for i, s := range v {
+			quoted[i] = strconv.Quote(s)
+		}
+		return "[" + strings.Join(quoted, ", ") + "]"
+	case map[string]string:
+		entries := make([]string, 0, len(v))
+		for _, k := range slices.Sorted(maps.Keys(v)) {
+			entries = append(entries, strconv.Quote(k)+" = "+strconv.Quote(v[k]))
+		}
+		return "{" + strings.Join(entries, ", ") + "}"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Validate checks the merged config, pointing to the source of every invalid value.
+func (c Config) Validate() error {
+	var errs []error
+	invalid := func(key string, err error) {
+		errs = append(errs, &ConfigError{Source: c.Sources[key], Key: key, Err: err})
+	}
+
+	if u, err := url.Parse(c.Endpoint); err != nil {
+		invalid("endpoint", err)
+	} else if !u.IsAbs() || u.Host == "" {
+		invalid("endpoint", fmt.Errorf("%q is not an absolute URL", c.Endpoint))
+	}
+
+	if c.Backend != BackendLlamaCpp {
+		invalid("backend", fmt.Errorf("unsupported backend %q, only %q is supported", c.Backend, BackendLlamaCpp))
+	}
+
+	if c.Timeout <= 0 {
+		invalid("timeout", fmt.Errorf("must be positive, got %s", c.Timeout))
+	}
+
+	if _, ok := c.PromptFormats[c.ModelType]; !ok {
+		invalid("model_type", fmt.Errorf("unknown model type %q", c.ModelType))
+	}
+
+	switch c.PromptStyle {
+	case PromptStylePlain, PromptStyleConventional:
+	default:
+		invalid("prompt_style", fmt.Errorf("unknown prompt style %q, expected %q or %q",
+			c.PromptStyle, PromptStylePlain, PromptStyleConventional))
+	}
+
+	if c.Sampling.Temperature < 0 {
+		invalid("sampling.temperature", fmt.Errorf("must not be negative, got %v", c.Sampling.Temperature))
+	}
+	if c.Sampling.TopP < 0 || c.Sampling.TopP > 1 {
+		invalid("sampling.top_p", fmt.Errorf("must be within [0, 1], got %v", c.Sampling.TopP))
+	}
+	if c.Sampling.TopK < 0 {
+		invalid("sampling.top_k", fmt.Errorf("must not be negative, got %d", c.Sampling.TopK))
+	}
+	if c.Sampling.NPredict == 0 || c.Sampling.NPredict < -1 {
+		invalid("sampling.n_predict", fmt.Errorf("must be positive or -1 (unlimited), got %d", c.Sampling.NPredict))
+	}
+
+	switch c.Body.Format {
+	case BodyFormatProse, BodyFormatBullets:
+	default:
+		invalid("body.format", fmt.Errorf("unknown body format %q, expected %q or %q",
+			c.Body.Format, BodyFormatProse, BodyFormatBullets))
+	}
+	if c.Body.NPredict == 0 || c.Body.NPredict < -1 {
+		invalid("body.n_predict", fmt.Errorf("must be positive or -1 (unlimited), got %d", c.Body.NPredict))
+	}
+
+	for _, tmpl := range []struct{ key, path string }{
+		{"prompt.template", c.Prompt.Template},
+		{"prompt.body_template", c.Prompt.BodyTemplate},
+	} {
+		key, path := tmpl.key, tmpl.path
+		if path == "" {
+			continue
+		}
+
+		path = c.configPath(key, path)
+		subjectPath, bodyPath := path, ""
+		if key == "prompt.body_template" {
+			subjectPath, bodyPath = "", path
+		}
+		if _, err := LoadPromptTemplates(subjectPath, bodyPath); err != nil {
+			invalid(key, err)
+		}
+	}
+
+	switch c.Diff.Engine {
+	case DiffEngineNative, DiffEngineGit:
+	default:
+		invalid("diff.engine", fmt.Errorf("unknown diff engine %q, expected %q or %q",
+			c.Diff.Engine, DiffEngineNative, DiffEngineGit))
+	}
+	if c.Diff.ContextLines < 0 {
+		invalid("diff.context_lines", fmt.Errorf("must not be negative, got %d", c.Diff.ContextLines))
+	}
+	validateGlobs := func(key string, globs []string) {
+		for _, glob := range globs {
+			if _, err := path.Match(strings.TrimPrefix(glob, "!"), ""); err != nil {
+				invalid(key, fmt.Errorf("invalid pattern %q: %w", glob, err))
+			}
+		}
+	}
+	validateGlobs("diff.include", c.Diff.Include)
+	validateGlobs("diff.exclude", c.Diff.Exclude)
+
+	if len(c.Conventional.Types) == 0 {
+		invalid("conventional.types", errors.New("must not be empty"))
+	}
+	for _, glob := range slices.Sorted(maps.Keys(c.Conventional.ScopeMap)) {
+		if _, err := path.Match(glob, ""); err != nil {
+			invalid("conventional.scope_map", fmt.Errorf("invalid pattern %q: %w", glob, err))
+		}
+		if scope := c.Conventional.ScopeMap[glob]; len(c.Conventional.Scopes) > 0 && !slices.Contains(c.Conventional.Scopes, scope) {
+			invalid("conventional.scope_map", fmt.Errorf("scope %q of %q isn't in conventional.scopes", scope, glob))
+		}
+	}
+
+	if c.History.Depth <= 0 {
+		invalid("history.depth", fmt.Errorf("must be positive, got %d", c.History.Depth))
+	}
+	if c.History.Examples < 0 {
+		invalid("history.examples", fmt.Errorf("must not be negative, got %d", c.History.Examples))
+	}
+
+	for _, p := range c.Ticket.Patterns {
+		if _, err := regexp.Compile(p); err != nil {
+			invalid("ticket.patterns", fmt.Errorf("invalid pattern %q: %w", p, err))
+		}
+	}
+	switch c.Ticket.Placement {
+	case TicketNone, TicketPrefix, TicketScope, TicketTrailer:
+	default:
+		invalid("ticket.placement", fmt.Errorf("unknown placement %q, expected %q, %q, %q or %q",
+			c.Ticket.Placement, TicketNone, TicketPrefix, TicketScope, TicketTrailer))
+	}
+	if !trailerRe.MatchString(c.Ticket.Trailer + ": ") {
+		invalid("ticket.trailer", fmt.Errorf("invalid trailer key %q", c.Ticket.Trailer))
+	}
+
+	switch c.Redact.Mode {
+	case RedactModeRedact, RedactModeRefuse:
+	default:
+		invalid("redact.mode", fmt.Errorf("unknown redact mode %q, expected %q or %q",
+			c.Redact.Mode, RedactModeRedact, RedactModeRefuse))
+	}
+	for _, p := range c.Redact.Patterns {
+		if _, err := regexp.Compile(p); err != nil {
+			invalid("redact.patterns", fmt.Errorf("invalid pattern %q: %w", p, err))
+		}
+	}
+
+	switch c.Commit.Sign {
+	case SignAuto, SignAlways, SignNever:
+	default:
+		invalid("commit.sign", fmt.Errorf("unknown sign mode %q, expected %q, %q or %q",
+			c.Commit.Sign, SignAuto, SignAlways, SignNever))
+	}
+	if c.Commit.Author != "" {
+		if _, err := ParseIdentity(c.Commit.Author); err != nil {
+			invalid("commit.author", err)
+		}
+	}
+
+	c.Keys.validate(func(action string, err error) {
+		invalid("keys."+action, err)
+	})
+
+	return errors.Join(errs...)
+}
+
+// validate checks that every action has a key and that no key is bound to several actions.
+func (k KeysConfig) validate(invalid func(action string, err error)) {
+	actions := []struct {
+		name string
+		keys []string
+	}{
+		{"commit", k.Commit},
+		{"regenerate", k.Regen},
+		{"abort", k.Abort},
+		{"body", k.Body},
+		{"newline", k.Newline},
+		{"help", k.Help},
+		{"quit", k.Quit},
+	}
+
+	boundTo := make(map[string]string)
+	for _, action := range actions {
+		if len(action.keys) == 0 {
+			invalid(action.name, errors.New("no keys bound"))
+		}
+
+		for _, key := range action.keys {
+			key = strings.TrimSpace(key)
+			if key == "" {
+				invalid(action.name, errors.New("empty key"))
+				continue
+			}
+
+			if other, ok := boundTo[key]; ok {
+				invalid(action.name, fmt.Errorf("key %q is already bound to %q", key, other))
+				continue
+			}
+			boundTo[key] = action.name
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package llame
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	userPath := filepath.Join(dir, "config.toml")
+	repoRoot := filepath.Join(dir, "repo")
+	require.NoError(t, os.Mkdir(repoRoot, 0o755))
+
+	writeFile := func(t *testing.T, path, content string) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+		t.Cleanup(func() { os.Remove(path) })
+	}
+
+	t.Run("missing files", func(t *testing.T) {
+		cfg, err := LoadConfig(userPath, repoRoot)
+		require.NoError(t, err)
+		assert.Equal(t, DefaultConfig(), cfg)
+	})
+
+	t.Run("layers", func(t *testing.T) {
+		writeFile(t, userPath, `
+endpoint = "http://10.0.0.2:8080/completion"
+timeout = "30s"
+
+[keys]
+regenerate = ["ctrl+g"]
+quit = ["ctrl+q"]
+
+[theme]
+text = "#333333"
+no_color = true
+`)
+		writeFile(t, filepath.Join(repoRoot, RepoConfigFileName), `
+# The repo prefers a faster model.
+timeout = "5s"
+model_type = "llama3"
+`)
+		t.Setenv("LLAME_MODEL_TYPE", "chatml")
+
+		cfg, err := LoadConfig(userPath, repoRoot, ConfigOverride{Key: "theme.error", Flag: "--error-color", Value: "9"})
+		require.NoError(t, err)
+
+		assert.Equal(t, "http://10.0.0.2:8080/completion", cfg.Endpoint)
+		assert.Equal(t, 5*time.Second, cfg.Timeout)
+		assert.Equal(t, "chatml", cfg.ModelType)
+		assert.Equal(t, []string{"ctrl+g"}, cfg.Keys.Regen)
+		assert.Equal(t, []string{"enter"}, cfg.Keys.Commit)
+		assert.Equal(t, "#333333", cfg.Theme.Text)
+		assert.Equal(t, "9", cfg.Theme.Error)
+		assert.True(t, cfg.Theme.ColorDisabled())
+
+		assert.Equal(t, ConfigSource{Layer: LayerUser, Name: userPath, Line: 2}, cfg.Sources["endpoint"])
+		assert.Equal(t, ConfigSource{Layer: LayerRepo, Name: filepath.Join(repoRoot, RepoConfigFileName), Line: 3}, cfg.Sources["timeout"])
+		assert.Equal(t, ConfigSource{Layer: LayerEnv, Name: "LLAME_MODEL_TYPE"}, cfg.Sources["model_type"])
+		assert.Equal(t, ConfigSource{Layer: LayerUser, Name: userPath, Line: 6}, cfg.Sources["keys.regenerate"])
+		assert.Equal(t, ConfigSource{Layer: LayerDefault}, cfg.Sources["keys.commit"])
+		assert.Equal(t, "flag --error-color", cfg.Sources["theme.error"].String())
+	})
+
+	t.Run("unknown keys", func(t *testing.T) {
+		writeFile(t, userPath, `
+endpoint = "http://127.0.0.1:8080/completion"
+
+[keys]
+comit = ["enter"]
+`)
+
+		_, err := LoadConfig(userPath, "")
+		require.Error(t, err)
+		assert.Equal(t, "user config "+userPath+":5: keys.comit: unknown key", err.Error())
+	})
+
+	t.Run("syntax error", func(t *testing.T) {
+		writeFile(t, userPath, "timeout = \"5s\"\nendpoint = \"http://127.0.0.1\n")
+
+		_, err := LoadConfig(userPath, "")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), userPath+":2: ")
+	})
+
+	t.Run("invalid values", func(t *testing.T) {
+		writeFile(t, userPath, `
+timeout = "-1s"
+model_type = "gpt"
+
+[keys]
+regenerate = ["enter"]
+abort = []
+
+[ticket]
+patterns = ["(PROJ"]
+placement = "suffix"
+
+[redact]
+mode = "warn"
+patterns = ["secret=(\\w+"]
+`)
+
+		_, err := LoadConfig(userPath, "")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), userPath+":2: timeout: must be positive")
+		assert.Contains(t, err.Error(), userPath+`:3: model_type: unknown model type "gpt"`)
+		assert.Contains(t, err.Error(), userPath+`:6: keys.regenerate: key "enter" is already bound to "commit"`)
+		assert.Contains(t, err.Error(), userPath+`:7: keys.abort: no keys bound`)
+		assert.Contains(t, err.Error(), userPath+`:10: ticket.patterns: invalid pattern "(PROJ"`)
+		assert.Contains(t, err.Error(), userPath+`:11: ticket.placement: unknown placement "suffix"`)
+		assert.Contains(t, err.Error(), userPath+`:14: redact.mode: unknown redact mode "warn"`)
+		assert.Contains(t, err.Error(), userPath+`:15: redact.patterns: invalid pattern "secret=(\\w+"`)
+	})
+
+	t.Run("tables", func(t *testing.T) {
+		repoConfig := filepath.Join(repoRoot, RepoConfigFileName)
+		writeFile(t, repoConfig, `
+[conventional]
+scopes = ["cli", "core"]
+
+[conventional.scope_map]
+"cmd/" = "cli"
+"*.go" = "core"
+`)
+
+		cfg, err := LoadConfig(userPath, repoRoot)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"cmd/": "cli", "*.go": "core"}, cfg.Conventional.ScopeMap)
+		assert.Equal(t, ConfigSource{Layer: LayerRepo, Name: repoConfig, Line: 5}, cfg.Sources["conventional.scope_map"])
+		assert.Contains(t, cfg.Entries(), ConfigEntry{
+			Key:    "conventional.scope_map",
+			Value:  `{"*.go" = "core", "cmd/" = "cli"}`,
+			Source: cfg.Sources["conventional.scope_map"],
+		})
+
+		writeFile(t, repoConfig, `
+[conventional]
+scopes = ["cli"]
+scope_map = { "cmd/" = "cli", "*.go" = "core" }
+`)
+		_, err = LoadConfig(userPath, repoRoot)
+		require.Error(t, err)
+		assert.Equal(t, "repo config "+repoConfig+`:4: conventional.scope_map: scope "core" of "*.go" isn't in conventional.scopes`, err.Error())
+	})
+
+	t.Run("profiles", func(t *testing.T) {
+		writeFile(t, userPath, `
+timeout = "10s"
+
+[profiles.fast]
+model_type = "llama3"
+endpoint = "http://127.0.0.1:8080/completion"
+
+[profiles.fast.sampling]
+temperature = 0.2
+
+[profiles.release]
+model_type = "chatml"
+endpoint = "http://10.0.0.70:8080/completion"
+timeout = "2m"
+prompt_style = "conventional"
+`)
+		repoConfig := filepath.Join(repoRoot, RepoConfigFileName)
+		writeFile(t, repoConfig, `
+profile = "fast"
+
+[profiles.fast]
+timeout = "3s"
+`)
+
+		cfg, err := LoadConfig(userPath, repoRoot)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"fast", "release"}, cfg.ProfileNames())
+		assert.Equal(t, "fast", cfg.Profile)
+		assert.Equal(t, "llama3", cfg.ModelType)
+		assert.Equal(t, 3*time.Second, cfg.Timeout)
+		assert.Equal(t, 0.2, cfg.Sampling.Temperature)
+		assert.Equal(t, 512, cfg.Sampling.NPredict)
+		assert.Equal(t, PromptStylePlain, cfg.PromptStyle)
+		assert.Equal(t, `profile "fast" in user config `+userPath+`:5`, cfg.Sources["model_type"].String())
+		assert.Equal(t, `profile "fast" in repo config `+repoConfig+`:5`, cfg.Sources["timeout"].String())
+
+		cfg, err = LoadConfig(userPath, repoRoot,
+			ConfigOverride{Key: "profile", Flag: "--profile", Value: "release"},
+			ConfigOverride{Key: "timeout", Flag: "--timeout", Value: "1m"},
+		)
+		require.NoError(t, err)
+		assert.Equal(t, "release", cfg.Profile)
+		assert.Equal(t, "chatml", cfg.ModelType)
+		assert.Equal(t, "http://10.0.0.70:8080/completion", cfg.Endpoint)
+		assert.Equal(t, time.Minute, cfg.Timeout)
+		assert.Equal(t, PromptStyleConventional, cfg.PromptStyle)
+		assert.Equal(t, 0.5, cfg.Sampling.Temperature)
+
+		_, err = LoadConfig(userPath, repoRoot, ConfigOverride{Key: "profile", Flag: "--profile", Value: "slow"})
+		require.Error(t, err)
+		assert.Equal(t, `flag --profile: profile: unknown profile "slow"`, err.Error())
+	})
+
+	t.Run("invalid profiles", func(t *testing.T) {
+		writeFile(t, userPath, `
+[profiles.fast]
+model_type = "llama3"
+keys = { quit = ["x"] }
+timeout = 5
+`)
+
+		_, err := LoadConfig(userPath, repoRoot)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), userPath+":4: profiles.fast.keys.quit: unknown profile key")
+	})
+
+	t.Run("untrusted repos", func(t *testing.T) {
+		repoConfig := filepath.Join(repoRoot, RepoConfigFileName)
+		outside := filepath.Join(dir, "outside.tmpl")
+		writeFile(t, outside, `{{.Diff}}`)
+		writeFile(t, filepath.Join(repoRoot, "subject.tmpl"), `{{.Diff}}`)
+		writeFile(t, repoConfig, `
+endpoint = "http://attacker.example:8080/completion"
+trusted_repos = ["/"]
+
+[prompt]
+template = "../outside.tmpl"
+body_template = "subject.tmpl"
+
+[redact]
+enabled = false
+
+[profiles.fast]
+backend = "llama.cpp"
+`)
+
+		cfg, err := LoadConfig(userPath, repoRoot, ConfigOverride{Key: "profile", Value: "fast"})
+		require.NoError(t, err, "the keys are ignored")
+		untrusted := `can only be set in the config of a trusted repository, add "` + repoRoot + `" to trusted_repos of the user config`
+		require.Len(t, cfg.Warnings, 5)
+		assert.EqualError(t, cfg.Warnings[0], "repo config "+repoConfig+":2: endpoint: "+untrusted)
+		assert.EqualError(t, cfg.Warnings[1], "repo config "+repoConfig+":3: trusted_repos: can only be set in the user config")
+		assert.EqualError(t, cfg.Warnings[2], "repo config "+repoConfig+":6: prompt.template: "+outside+
+			" is outside of the repository, which isn't in trusted_repos of the user config")
+		assert.EqualError(t, cfg.Warnings[3], "repo config "+repoConfig+":10: redact.enabled: "+untrusted)
+		assert.EqualError(t, cfg.Warnings[4], `profile "fast" in repo config `+repoConfig+":13: backend: "+untrusted)
+
+		defaults := DefaultConfig()
+		assert.Equal(t, defaults.Endpoint, cfg.Endpoint)
+		assert.Empty(t, cfg.TrustedRepos)
+		assert.Empty(t, cfg.Prompt.Template)
+		assert.Equal(t, filepath.Join(repoRoot, "subject.tmpl"), cfg.configPath("prompt.body_template", cfg.Prompt.BodyTemplate))
+		assert.True(t, cfg.Redact.Enabled)
+		assert.Equal(t, LayerDefault, cfg.Sources["endpoint"].Layer)
+		assert.Equal(t, LayerDefault, cfg.Sources["redact.enabled"].Layer)
+
+		writeFile(t, userPath, `trusted_repos = ["`+filepath.Join(dir, "*")+`"]`)
+		writeFile(t, repoConfig, `
+endpoint = "http://10.0.0.2:8080/completion"
+
+[prompt]
+template = "../outside.tmpl"
+
+[redact]
+enabled = false
+`)
+		cfg, err = LoadConfig(userPath, repoRoot)
+		require.NoError(t, err)
+		assert.Empty(t, cfg.Warnings)
+		assert.Equal(t, "http://10.0.0.2:8080/completion", cfg.Endpoint)
+		assert.False(t, cfg.Redact.Enabled)
+		assert.True(t, cfg.RepoTrusted(repoRoot))
+		assert.False(t, cfg.RepoTrusted(dir))
+	})
+
+	t.Run("prompt formats", func(t *testing.T) {
+		formatsDir := filepath.Join(dir, PromptFormatsDirName)
+		require.NoError(t, os.Mkdir(formatsDir, 0o755))
+		t.Cleanup(func() { os.RemoveAll(formatsDir) })
+		writeFile(t, filepath.Join(formatsDir, "granite.json"), `{"granite": {"template": "{{.History}}{{.Char}}"}}`)
+		writeFile(t, userPath, `model_type = "granite"`)
+
+		cfg, err := LoadConfig(userPath, repoRoot)
+		require.NoError(t, err)
+		assert.Equal(t, "granite", cfg.ModelType)
+		assert.Contains(t, cfg.PromptFormats, "llama3")
+
+		otherPath := filepath.Join(repoRoot, "config.toml")
+		writeFile(t, otherPath, `model_type = "granite"`)
+		_, err = LoadConfig(otherPath, repoRoot)
+		assert.ErrorContains(t, err, `model_type: unknown model type "granite"`, "formats are loaded next to the user config")
+	})
+}
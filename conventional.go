@@ -0,0 +1,228 @@
+package llame
+
+import (
+	"cmp"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+)
+
+// DefaultConventionalTypes are the types of the Conventional Commits specification
+// and the Angular convention.
+var DefaultConventionalTypes = []string{
+	"feat", "fix", "docs", "style", "refactor", "perf", "test", "build", "ci", "chore", "revert",
+}
+
+// conventionalScopesMax limits the number of scopes suggested to the model.
+const conventionalScopesMax = 3
+
+// ConventionalOptions restrict Conventional Commits messages, see ConventionalConfig.
+type ConventionalOptions struct {
+	Types    []string
+	Scopes   []string          // Any scope is allowed if empty
+	ScopeMap map[string]string // .gitignore patterns of paths to scopes
+}
+
+// Options returns the Conventional Commits options set in config.
+func (c ConventionalConfig) Options() ConventionalOptions {
+	return ConventionalOptions{
+		Types:    c.Types,
+		Scopes:   c.Scopes,
+		ScopeMap: c.ScopeMap,
+	}
+}
+
+// ConventionalHints are the type and the scopes likely fitting the changes.
+type ConventionalHints struct {
+	Type   string   // Empty if it can't be told from the changed files
+	Scopes []string // The most changed first
+}
+
+// InferConventional guesses the type and the scopes of the changes by the changed files.
+//
+// The type is only guessed if all the files are tests ("test"), documentation ("docs"),
+// build files ("build") or CI configs ("ci"). The scope of a file is taken from opts.ScopeMap,
+// or it's the top-level directory of the file, or the name of a file in the root.
+func InferConventional(d *Diff, opts ConventionalOptions) ConventionalHints {
+	var hints ConventionalHints
+	if d == nil || len(d.Files) == 0 {
+		return hints
+	}
+
+	kind := fileKind(d.Files[0].Path())
+	for _, f := range d.Files[1:] {
+		if fileKind(f.Path()) != kind {
+			kind = ""
+			break
+		}
+	}
+	if kind != "" && slices.Contains(opts.Types, kind) {
+		hints.Type = kind
+	}
+
+	scopeMap := newScopeMatcher(opts.ScopeMap)
+	changes := make(map[string]int)
+	for _, f := range d.Files {
+		scope := scopeMap.scope(f.Path())
+		if scope == "" || (len(opts.Scopes) > 0 && !slices.Contains(opts.Scopes, scope)) {
+			continue
+		}
+		// Files without changed lines (e.g. renamed) still count.
+		changes[scope] += f.Added + f.Deleted + 1
+	}
+
+	for scope := range changes {
+		hints.Scopes = append(hints.Scopes, scope)
+	}
+	slices.SortFunc(hints.Scopes, func(a, b string) int {
+		return cmp.Or(cmp.Compare(changes[b], changes[a]), cmp.Compare(a, b))
+	})
+	if len(hints.Scopes) > conventionalScopesMax {
+		hints.Scopes = hints.Scopes[:conventionalScopesMax]
+	}
+
+	return hints
+}
+
+// fileKind tells whether the file is a test ("test"), documentation ("docs"), a build file ("build")
+// or a CI config ("ci"), it's empty for other files.
+func fileKind(p string) string {
+	name := path.Base(p)
+	dirs := strings.Split(path.Dir(p), "/")
+
+	switch {
+	case strings.HasPrefix(p, ".github/workflows/") || strings.HasPrefix(p, ".circleci/") ||
+		name == ".gitlab-ci.yml" || name == ".travis.yml" || name == "Jenkinsfile":
+		return "ci"
+	case strings.HasSuffix(name, "_test.go") || strings.HasPrefix(name, "test_") ||
+		strings.Contains(name, ".test.") || strings.Contains(name, ".spec.") ||
+		slices.ContainsFunc(dirs, func(dir string) bool {
+			return dir == "testdata" || dir == "tests" || dir == "__tests__"
+		}):
+		return "test"
+	case slices.Contains(buildFiles, name) || slices.Contains(DefaultDiffExclude, name):
+		return "build"
+	case slices.Contains([]string{".md", ".rst", ".adoc", ".txt"}, strings.ToLower(path.Ext(name))) ||
+		strings.HasPrefix(name, "LICENSE") || slices.Contains(dirs, "docs"):
+		return "docs"
+	}
+
+	return ""
+}
+
+var buildFiles = []string{
+	"go.mod", "go.work", "go.work.sum", "Makefile", "Dockerfile", ".dockerignore", "package.json",
+	"Cargo.toml", "pyproject.toml", "setup.py", "requirements.txt", "Gemfile", "pom.xml",
+	"build.gradle", "CMakeLists.txt",
+}
+
+type scopeMatcher []struct {
+	pattern gitignore.Pattern
+	scope   string
+}
+
+// newScopeMatcher orders the patterns from the longest, more specific ones.
+func newScopeMatcher(scopeMap map[string]string) scopeMatcher {
+	patterns := make([]string, 0, len(scopeMap))
+	for p := range scopeMap {
+		patterns = append(patterns, p)
+	}
+	slices.SortFunc(patterns, func(a, b string) int {
+		return cmp.Or(cmp.Compare(len(b), len(a)), cmp.Compare(a, b))
+	})
+
+	m := make(scopeMatcher, len(patterns))
+	for i, p := range patterns {
+		m[i].pattern = gitignore.ParsePattern(p, nil)
+		m[i].scope = scopeMap[p]
+	}
+
+	return m
+}
+
+func (m scopeMatcher) scope(p string) string {
+	parts := strings.Split(p, "/")
+	for _, sm := range m {
+		if sm.pattern.Match(parts, false) == gitignore.Exclude {
+			return sm.scope
+		}
+	}
+
+	if len(parts) > 1 {
+		return parts[0]
+	}
+
+	// A file in the root is a scope of its own, along with its tests.
+	name := strings.TrimPrefix(parts[0], ".")
+	name = strings.TrimSuffix(name, path.Ext(name))
+	return strings.ToLower(strings.TrimSuffix(name, "_test"))
+}
+
+// ConventionalSubject is a parsed subject of a Conventional Commits message.
+type ConventionalSubject struct {
+	Type        string
+	Scopes      []string // Comma separated in the subject
+	Breaking    bool     // Marked with "!"
+	Description string
+}
+
+var conventionalSubjectRe = regexp.MustCompile(`^([a-zA-Z]+)(?:\(([^()]*)\))?(!)?: (\S.*)$`)
+
+// ParseConventionalSubject parses "<type>(<scope>)!: <description>", the scope and "!" are optional.
+func ParseConventionalSubject(subject string) (ConventionalSubject, error) {
+	m := conventionalSubjectRe.FindStringSubmatch(strings.TrimSpace(subject))
+	if m == nil {
+		return ConventionalSubject{}, fmt.Errorf("subject %q doesn't follow the '<type>(<scope>): <description>' format", subject)
+	}
+
+	s := ConventionalSubject{Type: m[1], Breaking: m[3] != "", Description: m[4]}
+	for _, scope := range strings.Split(m[2], ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			s.Scopes = append(s.Scopes, scope)
+		}
+	}
+
+	return s, nil
+}
+
+// String formats the subject back, scopes are separated with ", ".
+func (s ConventionalSubject) String() string {
+	subject := s.Type
+	if len(s.Scopes) > 0 {
+		subject += "(" + strings.Join(s.Scopes, ", ") + ")"
+	}
+	if s.Breaking {
+		subject += "!"
+	}
+
+	return subject + ": " + s.Description
+}
+
+// LintConventionalCommitMsg checks that the subject of the message follows Conventional Commits
+// with one of the allowed types and scopes.
+func LintConventionalCommitMsg(msg string, opts ConventionalOptions) error {
+	subject, _, _ := strings.Cut(strings.TrimSpace(msg), "\n")
+
+	s, err := ParseConventionalSubject(subject)
+	if err != nil {
+		return &LintError{Reason: err.Error()}
+	}
+
+	if !slices.Contains(opts.Types, s.Type) {
+		return &LintError{Reason: fmt.Sprintf("type %q isn't one of %s", s.Type, strings.Join(opts.Types, ", "))}
+	}
+
+	if len(opts.Scopes) > 0 {
+		for _, scope := range s.Scopes {
+			if !slices.Contains(opts.Scopes, scope) {
+				return &LintError{Reason: fmt.Sprintf("scope %q isn't one of %s", scope, strings.Join(opts.Scopes, ", "))}
+			}
+		}
+	}
+
+	return nil
+}
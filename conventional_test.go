@@ -0,0 +1,112 @@
+package llame
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func changedFiles(files map[string]int) *Diff {
+	d := &Diff{}
+	for p, added := range files {
+		d.Files = append(d.Files, &FileDiff{OldPath: p, NewPath: p, Change: ChangeModify, Added: added})
+	}
+	return d
+}
+
+func TestInferConventional(t *testing.T) {
+	opts := ConventionalOptions{Types: DefaultConventionalTypes}
+
+	for _, tc := range []struct {
+		name  string
+		files map[string]int
+		want  ConventionalHints
+	}{
+		{
+			name:  "tests",
+			files: map[string]int{"config_test.go": 10, "cmd/testdata/config.toml": 2},
+			want:  ConventionalHints{Type: "test", Scopes: []string{"config", "cmd"}},
+		},
+		{
+			name:  "docs",
+			files: map[string]int{"README.MD": 3, "docs/usage.md": 10},
+			want:  ConventionalHints{Type: "docs", Scopes: []string{"docs", "readme"}},
+		},
+		{
+			name:  "build",
+			files: map[string]int{"go.mod": 1, "go.sum": 4},
+			want:  ConventionalHints{Type: "build", Scopes: []string{"go"}},
+		},
+		{
+			name:  "ci",
+			files: map[string]int{".github/workflows/test.yml": 1},
+			want:  ConventionalHints{Type: "ci", Scopes: []string{".github"}},
+		},
+		{
+			name:  "mixed",
+			files: map[string]int{"cmd/main.go": 20, "cmd/tui.go": 5, "config.go": 10, "config_test.go": 10, "go.mod": 1, "diff.go": 1},
+			want:  ConventionalHints{Scopes: []string{"cmd", "config", "diff"}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, InferConventional(changedFiles(tc.files), opts))
+		})
+	}
+
+	t.Run("scope map", func(t *testing.T) {
+		opts := ConventionalOptions{
+			Types:    DefaultConventionalTypes,
+			ScopeMap: map[string]string{"cmd/": "cli", "cmd/tui.go": "tui", "*.toml": "config"},
+		}
+		hints := InferConventional(changedFiles(map[string]int{"cmd/main.go": 1, "cmd/tui.go": 5, "cmd/testdata/llame.toml": 1}), opts)
+		assert.Equal(t, []string{"tui", "cli", "config"}, hints.Scopes)
+
+		opts.Scopes = []string{"cli", "config"}
+		hints = InferConventional(changedFiles(map[string]int{"cmd/main.go": 1, "cmd/tui.go": 5, "llama.go": 10}), opts)
+		assert.Equal(t, []string{"cli"}, hints.Scopes)
+	})
+
+	t.Run("disallowed type", func(t *testing.T) {
+		hints := InferConventional(changedFiles(map[string]int{"README.md": 1}), ConventionalOptions{Types: []string{"feat", "fix"}})
+		assert.Empty(t, hints.Type)
+	})
+
+	assert.Equal(t, ConventionalHints{}, InferConventional(nil, opts))
+}
+
+func TestParseConventionalSubject(t *testing.T) {
+	s, err := ParseConventionalSubject("feat(cmd, config)!: add profiles")
+	require.NoError(t, err)
+	assert.Equal(t, ConventionalSubject{Type: "feat", Scopes: []string{"cmd", "config"}, Breaking: true, Description: "add profiles"}, s)
+
+	s, err = ParseConventionalSubject("fix: typo")
+	require.NoError(t, err)
+	assert.Equal(t, ConventionalSubject{Type: "fix", Description: "typo"}, s)
+
+	for _, subject := range []string{"Fix typo", "fix:typo", "fix(cmd: typo", "(cmd): typo", "fix: "} {
+		_, err := ParseConventionalSubject(subject)
+		assert.Error(t, err, subject)
+	}
+}
+
+func TestLintConventionalCommitMsg(t *testing.T) {
+	opts := ConventionalOptions{Types: []string{"feat", "fix"}, Scopes: []string{"cli", "tui"}}
+
+	assert.NoError(t, LintConventionalCommitMsg("feat(tui): add help\n\nBody.", opts))
+	assert.NoError(t, LintConventionalCommitMsg("fix: crash on start", opts))
+
+	for msg, reason := range map[string]string{
+		"Add help":               `subject "Add help" doesn't follow the '<type>(<scope>): <description>' format`,
+		"docs: update README":    `type "docs" isn't one of feat, fix`,
+		"feat(cli,config): flag": `scope "config" isn't one of cli, tui`,
+	} {
+		err := LintConventionalCommitMsg(msg, opts)
+		var lintErr *LintError
+		require.ErrorAs(t, err, &lintErr, msg)
+		assert.Equal(t, reason, lintErr.Reason)
+	}
+
+	opts.Scopes = nil
+	assert.NoError(t, LintConventionalCommitMsg("feat(anything): add help", opts))
+}
@@ -0,0 +1,530 @@
+package llame
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+type DiffEngine string
+
+const (
+	DiffEngineNative DiffEngine = "native" // go-git, falling back to git if it fails
+	DiffEngineGit    DiffEngine = "git"    // The git binary
+)
+
+// DiffOptions configure staged diffs.
+type DiffOptions struct {
+	Engine        DiffEngine
+	ContextLines  int  // Unchanged lines around changes, like `git diff -U<n>`.
+	DetectRenames bool // Pair deleted and added files with similar content.
+
+	// Files to summarize instead of showing their diffs, see DiffFilter.
+	Include    []string // .gitignore patterns of the files to show even if excluded
+	Exclude    []string // .gitignore patterns of the files to summarize
+	Attributes bool     // Summarize files marked generated, vendored, binary or -diff in .gitattributes
+}
+
+func DefaultDiffOptions() DiffOptions {
+	return DiffOptions{
+		Engine:        DiffEngineNative,
+		ContextLines:  fdiff.DefaultContextLines,
+		DetectRenames: true,
+		Exclude:       slices.Clone(DefaultDiffExclude),
+		Attributes:    true,
+	}
+}
+
+const (
+	// binaryCheckLen is how much of a file is checked for NUL bytes, the same as git does.
+	binaryCheckLen = 8000
+	// renameSimilarityMin is git's default minimal similarity of renamed files in percent.
+	renameSimilarityMin = 50
+	// renameCandidatesMax limits the number of file pairs compared for inexact renames.
+	renameCandidatesMax = 100 * 100
+)
+
+var UnmergedFilesErr = errors.New("index has unmerged files")
+
+// GitDiffStagedFrom is like GitDiffStaged, but diffs the staged files against the given revision.
+func GitDiffStagedFrom(ctx context.Context, rev string, opts DiffOptions, files ...string) (*Diff, error) {
+	var (
+		diffs []byte
+		err   error
+	)
+
+	if opts.Engine != DiffEngineGit {
+		diffs, err = nativeDiffStaged(rev, opts, files...)
+		if err != nil {
+			Debugf("Native diff failed, falling back to git: %s", err)
+			diffs, err = execDiffStaged(ctx, rev, opts, files...)
+		}
+	} else {
+		diffs, err = execDiffStaged(ctx, rev, opts, files...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(diffs) == 0 {
+		return nil, NoStagedFilesErr
+	}
+
+	d, err := ParseDiff(string(diffs))
+	if err != nil {
+		return nil, err
+	}
+	filterDiff(d, opts)
+
+	return d, nil
+}
+
+// filterDiff applies DiffFilter, leaving the diff as is if it fails.
+func filterDiff(d *Diff, opts DiffOptions) {
+	repo, err := NewGitRepo()
+	if err != nil {
+		Debugf("Not filtering the diff: %s", err)
+		return
+	}
+
+	filter, err := NewDiffFilter(repo, opts)
+	if err != nil {
+		Debugf("Not filtering the diff: %s", err)
+		return
+	}
+
+	if err := filter.Apply(d); err != nil {
+		Debugf("Not filtering the diff: %s", err)
+	}
+}
+
+func nativeDiffStaged(rev string, opts DiffOptions, files ...string) ([]byte, error) {
+	repo, err := NewGitRepo()
+	if err != nil {
+		return nil, err
+	}
+
+	var base *object.Tree
+	if rev != EmptyTreeHash {
+		hash, err := repo.ResolveRevision(plumbing.Revision(rev))
+		if err != nil {
+			return nil, fmt.Errorf("resolve %s: %w", rev, err)
+		}
+
+		commit, err := repo.CommitObject(*hash)
+		if err != nil {
+			return nil, err
+		}
+
+		if base, err = commit.Tree(); err != nil {
+			return nil, err
+		}
+	}
+
+	return StagedDiff(repo, base, opts, files...)
+}
+
+// execDiffStaged runs `git diff`, disabling the settings that change its output format.
+func execDiffStaged(ctx context.Context, rev string, opts DiffOptions, files ...string) ([]byte, error) {
+	args := []string{
+		"diff", "--staged", "--no-color", "--no-ext-diff", "--src-prefix=a/", "--dst-prefix=b/",
+		"-U" + strconv.Itoa(opts.ContextLines),
+	}
+	if opts.DetectRenames {
+		args = append(args, "-M")
+	} else {
+		args = append(args, "--no-renames")
+	}
+	args = append(append(args, rev, "--"), files...)
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+	var changes bytes.Buffer
+	cmd.Stdout = &changes
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+
+	return changes.Bytes(), nil
+}
+
+// StagedDiff generates a unified diff between the base tree (the empty tree if nil) and
+// the index of the repository with go-git only. If files are given, the diff is limited to them
+// (or to the files in them, if they are directories).
+func StagedDiff(repo *git.Repository, base *object.Tree, opts DiffOptions, files ...string) ([]byte, error) {
+	from, err := treeFiles(base)
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := indexFiles(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []*filePatch
+	for path, f := range from {
+		if !matchesPaths(path, files) {
+			continue
+		}
+		if t, ok := to[path]; !ok {
+			changes = append(changes, &filePatch{from: f})
+		} else if t.hash != f.hash || t.mode != f.mode {
+			changes = append(changes, &filePatch{from: f, to: t})
+		}
+	}
+	for path, t := range to {
+		if _, ok := from[path]; !ok && matchesPaths(path, files) {
+			changes = append(changes, &filePatch{to: t})
+		}
+	}
+
+	for _, fp := range changes {
+		for _, f := range []*diffFile{fp.from, fp.to} {
+			if f == nil {
+				continue
+			}
+			if err := f.load(repo); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if opts.DetectRenames {
+		changes = detectRenames(changes)
+	}
+
+	slices.SortFunc(changes, func(a, b *filePatch) int {
+		return cmp.Compare(a.path(), b.path())
+	})
+
+	for _, fp := range changes {
+		fp.computeChunks()
+	}
+
+	var buf bytes.Buffer
+	encoder := fdiff.NewUnifiedEncoder(&buf, opts.ContextLines)
+	if err := encoder.Encode(patch(changes)); err != nil {
+		return nil, err
+	}
+
+	// Full hashes are of no use for the model, abbreviate them like git does.
+	return indexLineRe.ReplaceAll(buf.Bytes(), []byte("index $1..$2")), nil
+}
+
+var indexLineRe = regexp.MustCompile(`(?m)^index ([0-9a-f]{7})[0-9a-f]{33}\.\.([0-9a-f]{7})[0-9a-f]{33}`)
+
+func matchesPaths(path string, paths []string) bool {
+	if len(paths) == 0 {
+		return true
+	}
+
+	for _, p := range paths {
+		p = strings.TrimSuffix(p, "/")
+		if path == p || strings.HasPrefix(path, p+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func treeFiles(tree *object.Tree) (map[string]*diffFile, error) {
+	files := make(map[string]*diffFile)
+	if tree == nil {
+		return files, nil
+	}
+
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+
+	for {
+		path, entry, err := walker.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if entry.Mode == filemode.Dir {
+			continue
+		}
+
+		files[path] = &diffFile{path: path, hash: entry.Hash, mode: entry.Mode}
+	}
+
+	return files, nil
+}
+
+func indexFiles(repo *git.Repository) (map[string]*diffFile, error) {
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]*diffFile, len(idx.Entries))
+	for _, e := range idx.Entries {
+		if e.Stage != 0 {
+			return nil, fmt.Errorf("%w: %s", UnmergedFilesErr, e.Name)
+		}
+		if e.IntentToAdd {
+			// Only the path is recorded, like git, don't show it as a staged change.
+			continue
+		}
+
+		files[e.Name] = &diffFile{path: e.Name, hash: e.Hash, mode: e.Mode}
+	}
+
+	return files, nil
+}
+
+// diffFile implements diff.File.
+type diffFile struct {
+	path    string
+	hash    plumbing.Hash
+	mode    filemode.FileMode
+	content string
+	binary  bool
+}
+
+func (f *diffFile) Hash() plumbing.Hash     { return f.hash }
+func (f *diffFile) Mode() filemode.FileMode { return f.mode }
+func (f *diffFile) Path() string            { return f.path }
+
+func (f *diffFile) load(repo *git.Repository) error {
+	if f.mode == filemode.Submodule {
+		// The same as git shows for submodules.
+		f.content = "Subproject commit " + f.hash.String() + "\n"
+		return nil
+	}
+
+	blob, err := repo.BlobObject(f.hash)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", f.path, err)
+	}
+
+	r, err := blob.Reader()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	f.content = string(content)
+	f.binary = bytes.IndexByte(content[:min(len(content), binaryCheckLen)], 0) != -1
+
+	return nil
+}
+
+// filePatch implements diff.FilePatch.
+type filePatch struct {
+	from, to *diffFile
+	chunks   []fdiff.Chunk
+}
+
+func (p *filePatch) IsBinary() bool {
+	return (p.from != nil && p.from.binary) || (p.to != nil && p.to.binary)
+}
+
+func (p *filePatch) Files() (fdiff.File, fdiff.File) {
+	// Typed nils must not get into the interfaces.
+	var from, to fdiff.File
+	if p.from != nil {
+		from = p.from
+	}
+	if p.to != nil {
+		to = p.to
+	}
+
+	return from, to
+}
+
+func (p *filePatch) Chunks() []fdiff.Chunk {
+	return p.chunks
+}
+
+func (p *filePatch) path() string {
+	if p.to != nil {
+		return p.to.path
+	}
+
+	return p.from.path
+}
+
+func (p *filePatch) computeChunks() {
+	if p.IsBinary() {
+		return
+	}
+
+	var fromContent, toContent string
+	if p.from != nil {
+		fromContent = p.from.content
+	}
+	if p.to != nil {
+		toContent = p.to.content
+	}
+
+	for _, d := range diff.Do(fromContent, toContent) {
+		op := fdiff.Equal
+		switch d.Type {
+		case diffmatchpatch.DiffInsert:
+			op = fdiff.Add
+		case diffmatchpatch.DiffDelete:
+			op = fdiff.Delete
+		}
+
+		p.chunks = append(p.chunks, chunk{content: d.Text, op: op})
+	}
+}
+
+// chunk implements diff.Chunk.
+type chunk struct {
+	content string
+	op      fdiff.Operation
+}
+
+func (c chunk) Content() string       { return c.content }
+func (c chunk) Type() fdiff.Operation { return c.op }
+
+// patch implements diff.Patch.
+type patch []*filePatch
+
+func (p patch) FilePatches() []fdiff.FilePatch {
+	patches := make([]fdiff.FilePatch, len(p))
+	for i, fp := range p {
+		patches[i] = fp
+	}
+
+	return patches
+}
+
+func (p patch) Message() string {
+	return ""
+}
+
+// detectRenames pairs deleted and added files, first the ones with the same content and then
+// the ones at least renameSimilarityMin percent similar, like git does by default.
+func detectRenames(changes []*filePatch) []*filePatch {
+	var deleted, added []*filePatch
+	for _, fp := range changes {
+		switch {
+		case fp.to == nil:
+			deleted = append(deleted, fp)
+		case fp.from == nil:
+			added = append(added, fp)
+		}
+	}
+
+	paired := make(map[*filePatch]bool)
+	rename := func(del, add *filePatch) {
+		add.from = del.from
+		paired[del], paired[add] = true, true
+	}
+
+	for _, add := range added {
+		for _, del := range deleted {
+			if !paired[del] && del.from.hash == add.to.hash {
+				rename(del, add)
+				break
+			}
+		}
+	}
+
+	if len(deleted)*len(added) <= renameCandidatesMax {
+		type candidate struct {
+			del, add *filePatch
+			score    int
+		}
+
+		var candidates []candidate
+		lines := make(map[*filePatch]map[string]int)
+		for _, add := range added {
+			if paired[add] || add.to.binary {
+				continue
+			}
+			for _, del := range deleted {
+				if paired[del] || del.from.binary {
+					continue
+				}
+
+				// The smaller file can't be similar enough if it's only a part of the bigger one.
+				a, b := len(del.from.content), len(add.to.content)
+				if min(a, b)*100 < max(a, b)*renameSimilarityMin {
+					continue
+				}
+
+				if lines[del] == nil {
+					lines[del] = lineCounts(del.from.content)
+				}
+				if score := similarity(lines[del], a, add.to.content); score >= renameSimilarityMin {
+					candidates = append(candidates, candidate{del: del, add: add, score: score})
+				}
+			}
+		}
+
+		slices.SortStableFunc(candidates, func(a, b candidate) int {
+			return cmp.Compare(b.score, a.score)
+		})
+		for _, c := range candidates {
+			if !paired[c.del] && !paired[c.add] {
+				rename(c.del, c.add)
+			}
+		}
+	}
+
+	// Deleted files are represented by the added ones they are renamed to.
+	return slices.DeleteFunc(changes, func(fp *filePatch) bool {
+		return fp.to == nil && paired[fp]
+	})
+}
+
+// similarity returns the percentage of the content that is the same in both files: the size
+// of the lines of b found in a, given by its line counts (see lineCounts) and size. Like git's
+// hashes of chunks, the order of the lines is ignored, which is cheaper than diffing the files.
+func similarity(a map[string]int, aLen int, b string) int {
+	size := max(aLen, len(b))
+	if size == 0 {
+		return 100
+	}
+
+	var same int
+	used := make(map[string]int)
+	for _, line := range strings.SplitAfter(b, "\n") {
+		if used[line] < a[line] {
+			used[line]++
+			same += len(line)
+		}
+	}
+
+	return same * 100 / size
+}
+
+// lineCounts returns how many times every line (with its line ending) is in s.
+func lineCounts(s string) map[string]int {
+	counts := make(map[string]int)
+	for _, line := range strings.SplitAfter(s, "\n") {
+		counts[line]++
+	}
+
+	return counts
+}
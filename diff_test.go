@@ -0,0 +1,162 @@
+package llame
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memRepo struct {
+	t        *testing.T
+	repo     *git.Repository
+	workTree *git.Worktree
+}
+
+func newMemRepo(t *testing.T) *memRepo {
+	repo, err := git.Init(memory.NewStorage(), memfs.New())
+	require.NoError(t, err)
+
+	workTree, err := repo.Worktree()
+	require.NoError(t, err)
+
+	return &memRepo{t: t, repo: repo, workTree: workTree}
+}
+
+func (r *memRepo) stage(path, content string) {
+	require.NoError(r.t, util.WriteFile(r.workTree.Filesystem, path, []byte(content), 0o644))
+	_, err := r.workTree.Add(path)
+	require.NoError(r.t, err)
+}
+
+func (r *memRepo) remove(path string) {
+	_, err := r.workTree.Remove(path)
+	require.NoError(r.t, err)
+}
+
+func (r *memRepo) commit() *object.Tree {
+	hash, err := r.workTree.Commit("commit", &git.CommitOptions{
+		Author: &object.Signature{Name: "llame", Email: "llame@example.com", When: time.Now()},
+	})
+	require.NoError(r.t, err)
+
+	commit, err := r.repo.CommitObject(hash)
+	require.NoError(r.t, err)
+
+	tree, err := commit.Tree()
+	require.NoError(r.t, err)
+
+	return tree
+}
+
+func (r *memRepo) diff(base *object.Tree, opts DiffOptions, files ...string) string {
+	d, err := StagedDiff(r.repo, base, opts, files...)
+	require.NoError(r.t, err)
+	return string(d)
+}
+
+func numberedLines(from, to int) string {
+	var sb strings.Builder
+	for i := from; i <= to; i++ {
+		fmt.Fprintf(&sb, "line %d\n", i)
+	}
+	return sb.String()
+}
+
+func TestStagedDiff(t *testing.T) {
+	t.Run("initial commit", func(t *testing.T) {
+		r := newMemRepo(t)
+		r.stage("README", "llame\n")
+
+		d := r.diff(nil, DefaultDiffOptions())
+		assert.Contains(t, d, "diff --git a/README b/README\nnew file mode 100644\nindex 0000000..12eb1aa\n")
+		assert.Contains(t, d, "--- /dev/null\n+++ b/README\n@@ -0,0 +1 @@\n+llame\n")
+	})
+
+	t.Run("context lines", func(t *testing.T) {
+		r := newMemRepo(t)
+		r.stage("file", numberedLines(1, 20))
+		base := r.commit()
+
+		r.stage("file", strings.Replace(numberedLines(1, 20), "line 10\n", "line changed\n", 1))
+
+		d := r.diff(base, DefaultDiffOptions())
+		assert.Contains(t, d, "@@ -7,7 +7,7 @@ line 6\n")
+
+		opts := DefaultDiffOptions()
+		opts.ContextLines = 1
+		d = r.diff(base, opts)
+		assert.Contains(t, d, "@@ -9,3 +9,3 @@ line 8\n line 9\n-line 10\n+line changed\n line 11\n")
+	})
+
+	t.Run("added and deleted files", func(t *testing.T) {
+		r := newMemRepo(t)
+		r.stage("old", "old\n")
+		r.stage("kept", "kept\n")
+		base := r.commit()
+
+		r.remove("old")
+		r.stage("dir/new", "new\n")
+
+		d := r.diff(base, DefaultDiffOptions())
+		assert.Contains(t, d, "diff --git a/dir/new b/dir/new\nnew file mode 100644\n")
+		assert.Contains(t, d, "diff --git a/old b/old\ndeleted file mode 100644\n")
+		assert.NotContains(t, d, "kept")
+		assert.Less(t, strings.Index(d, "a/dir/new"), strings.Index(d, "a/old"), "files are sorted")
+
+		d = r.diff(base, DefaultDiffOptions(), "dir")
+		assert.Contains(t, d, "b/dir/new")
+		assert.NotContains(t, d, "a/old")
+	})
+
+	t.Run("renames", func(t *testing.T) {
+		r := newMemRepo(t)
+		r.stage("same.go", numberedLines(1, 10))
+		r.stage("similar.go", numberedLines(11, 30))
+		r.stage("different.go", numberedLines(31, 40))
+		r.stage("shrunk.go", numberedLines(41, 70))
+		base := r.commit()
+
+		r.remove("same.go")
+		r.remove("similar.go")
+		r.remove("different.go")
+		r.remove("shrunk.go")
+		r.stage("moved/same.go", numberedLines(1, 10))
+		r.stage("moved/similar.go", numberedLines(11, 29)+"line changed\n")
+		r.stage("moved/different.go", "something else\n")
+		r.stage("moved/shrunk.go", numberedLines(41, 50))
+
+		d := r.diff(base, DefaultDiffOptions())
+		assert.Contains(t, d, "diff --git a/same.go b/moved/same.go\nrename from same.go\nrename to moved/same.go\n")
+		assert.NotContains(t, d, "+++ b/moved/same.go", "content of exact renames isn't shown")
+		assert.Contains(t, d, "diff --git a/similar.go b/moved/similar.go\nrename from similar.go\nrename to moved/similar.go\n")
+		assert.Contains(t, d, "-line 30\n+line changed\n")
+		assert.Contains(t, d, "diff --git a/different.go b/different.go\ndeleted file mode 100644\n")
+		assert.Contains(t, d, "diff --git a/moved/different.go b/moved/different.go\nnew file mode 100644\n")
+		assert.Contains(t, d, "diff --git a/moved/shrunk.go b/moved/shrunk.go\nnew file mode 100644\n",
+			"a third of the file isn't similar enough, even if all its lines are kept")
+
+		opts := DefaultDiffOptions()
+		opts.DetectRenames = false
+		d = r.diff(base, opts)
+		assert.NotContains(t, d, "rename from")
+		assert.Equal(t, 8, strings.Count(d, "diff --git"))
+	})
+
+	t.Run("binary files", func(t *testing.T) {
+		r := newMemRepo(t)
+		r.stage("image.png", "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+		d := r.diff(nil, DefaultDiffOptions())
+		assert.Contains(t, d, "Binary files /dev/null and b/image.png differ\n")
+		assert.NotContains(t, d, "IHDR")
+	})
+}
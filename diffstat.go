@@ -0,0 +1,168 @@
+package llame
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Stat renders the diff like `git diff --stat=<width>`, except that binary files are shown without
+// their sizes.
+func (d *Diff) Stat(width int) string {
+	if d == nil || len(d.Files) == 0 {
+		return " 0 files changed\n"
+	}
+
+	type statLine struct {
+		name    string
+		changes int
+		binary  bool
+	}
+
+	var (
+		lines              = make([]statLine, len(d.Files))
+		maxLen, maxChange  int
+		numberWidth        int
+		files, added, dels = d.Stats()
+	)
+	for i, f := range d.Files {
+		name := f.Path()
+		if f.OldPath != f.NewPath && (f.Change == ChangeRename || f.Change == ChangeCopy) {
+			name = renameName(f.OldPath, f.NewPath)
+		}
+
+		lines[i] = statLine{name: name, changes: f.Added + f.Deleted, binary: f.Binary}
+		maxLen = max(maxLen, utf8.RuneCountInString(name))
+		if f.Binary {
+			// Change counts are aligned with "Bin".
+			numberWidth = 3
+			continue
+		}
+		maxChange = max(maxChange, lines[i].changes)
+	}
+	numberWidth = max(numberWidth, len(strconv.Itoa(maxChange)))
+
+	// The widths are calculated the same way as git does, with the graph part guaranteed 3/8 and
+	// the name part 5/8 of the minimal width. Besides the name and the graph parts, there is
+	// " | " and the number, and a space before the name and after the graph.
+	width = max(width, 16+6+numberWidth)
+	graphWidth, nameWidth := maxChange, maxLen
+	if nameWidth+numberWidth+6+graphWidth > width {
+		if graphWidth > width*3/8-numberWidth-6 {
+			graphWidth = max(width*3/8-numberWidth-6, 6)
+		}
+
+		if nameWidth > width-numberWidth-6-graphWidth {
+			nameWidth = width - numberWidth - 6 - graphWidth
+		} else {
+			graphWidth = width - numberWidth - 6 - nameWidth
+		}
+	}
+
+	var sb strings.Builder
+	for i, f := range d.Files {
+		line := lines[i]
+
+		name, prefix := line.name, ""
+		if nameLen := utf8.RuneCountInString(name); nameWidth < nameLen {
+			// Keep the end of the name, starting from a directory if possible.
+			prefix = "..."
+			keep := max(nameWidth-len(prefix), 0)
+			for ; nameLen > keep; nameLen-- {
+				_, size := utf8.DecodeRuneInString(name)
+				name = name[size:]
+			}
+			if slash := strings.IndexByte(name, '/'); slash != -1 {
+				name = name[slash:]
+			}
+		}
+		padding := max(nameWidth-len(prefix)-utf8.RuneCountInString(name), 0)
+
+		if line.binary {
+			fmt.Fprintf(&sb, " %s%s%s | %*s\n", prefix, name, strings.Repeat(" ", padding), numberWidth, "Bin")
+			continue
+		}
+
+		fmt.Fprintf(&sb, " %s%s%s | %*d", prefix, name, strings.Repeat(" ", padding), numberWidth, line.changes)
+		if line.changes > 0 {
+			sb.WriteByte(' ')
+		}
+
+		add, del := f.Added, f.Deleted
+		if graphWidth <= maxChange {
+			total := scaleLinear(add+del, graphWidth, maxChange)
+			if total < 2 && add > 0 && del > 0 {
+				total = 2
+			}
+			if add < del {
+				add = scaleLinear(add, graphWidth, maxChange)
+				del = total - add
+			} else {
+				del = scaleLinear(del, graphWidth, maxChange)
+				add = total - del
+			}
+		}
+		sb.WriteString(strings.Repeat("+", add) + strings.Repeat("-", del) + "\n")
+	}
+
+	fmt.Fprintf(&sb, " %d file%s changed", files, plural(files))
+	if added > 0 || dels == 0 {
+		fmt.Fprintf(&sb, ", %d insertion%s(+)", added, plural(added))
+	}
+	if dels > 0 || added == 0 {
+		fmt.Fprintf(&sb, ", %d deletion%s(-)", dels, plural(dels))
+	}
+	sb.WriteByte('\n')
+
+	return sb.String()
+}
+
+// scaleLinear scales the number of changes to the width, keeping at least one column for any change.
+func scaleLinear(n, width, maxChange int) int {
+	if n == 0 {
+		return 0
+	}
+
+	return 1 + n*(width-1)/maxChange
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+
+	return "s"
+}
+
+// renameName shortens a renamed path like git does, e.g. "cmd/{old => new}/main.go".
+func renameName(oldPath, newPath string) string {
+	// The common prefix ends and the common suffix starts with a slash.
+	var prefixLen int
+	for i := 0; i < len(oldPath) && i < len(newPath) && oldPath[i] == newPath[i]; i++ {
+		if oldPath[i] == '/' {
+			prefixLen = i + 1
+		}
+	}
+
+	// With a common prefix, the suffix may start at its slash.
+	adjust := 0
+	if prefixLen > 0 {
+		adjust = 1
+	}
+
+	var suffixLen int
+	for o, n := len(oldPath)-1, len(newPath)-1; o >= prefixLen-adjust && n >= prefixLen-adjust && oldPath[o] == newPath[n]; o, n = o-1, n-1 {
+		if oldPath[o] == '/' {
+			suffixLen = len(oldPath) - o
+		}
+	}
+
+	oldMid := oldPath[prefixLen:max(len(oldPath)-suffixLen, prefixLen)]
+	newMid := newPath[prefixLen:max(len(newPath)-suffixLen, prefixLen)]
+	if prefixLen+suffixLen == 0 {
+		return oldMid + " => " + newMid
+	}
+
+	return oldPath[:prefixLen] + "{" + oldMid + " => " + newMid + "}" + oldPath[len(oldPath)-suffixLen:]
+}
@@ -0,0 +1,232 @@
+package llame
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/format/gitattributes"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+)
+
+// DefaultDiffExclude are the files whose changes are rarely meaningful for a commit message.
+var DefaultDiffExclude = []string{
+	"go.sum",
+	"package-lock.json",
+	"yarn.lock",
+	"pnpm-lock.yaml",
+	"Cargo.lock",
+	"poetry.lock",
+	"Gemfile.lock",
+	"composer.lock",
+	"vendor/",
+	"node_modules/",
+}
+
+// Attributes marking files as not worth showing, see https://github.com/github-linguist/linguist.
+const (
+	attrDiff              = "diff"
+	attrBinary            = "binary"
+	attrLinguistGenerated = "linguist-generated"
+	attrLinguistVendored  = "linguist-vendored"
+)
+
+// DiffFilter replaces the diffs of files not worth showing to the model (lockfiles, generated
+// and vendored code) with one line summaries like "go.sum: 40 lines changed".
+//
+// A file is summarized if it matches an exclude pattern, or if it's marked with the -diff, binary,
+// linguist-generated or linguist-vendored gitattributes. Include patterns take precedence over both.
+// Patterns follow the .gitignore syntax.
+type DiffFilter struct {
+	include, exclude gitignore.Matcher
+
+	attributes bool
+	repo       *git.Repository  // To read .gitattributes from the index, like the staged files
+	gitDir     billy.Filesystem // To read info/attributes from, nil if the repository isn't on the filesystem
+}
+
+func NewDiffFilter(repo *git.Repository, opts DiffOptions) (*DiffFilter, error) {
+	f := &DiffFilter{
+		include:    newGlobMatcher(opts.Include),
+		exclude:    newGlobMatcher(opts.Exclude),
+		attributes: opts.Attributes,
+	}
+
+	if f.attributes {
+		f.repo = repo
+		if storage, ok := repo.Storer.(*filesystem.Storage); ok {
+			f.gitDir = storage.Filesystem()
+		}
+	}
+
+	return f, nil
+}
+
+func newGlobMatcher(globs []string) gitignore.Matcher {
+	patterns := make([]gitignore.Pattern, len(globs))
+	for i, glob := range globs {
+		patterns[i] = gitignore.ParsePattern(glob, nil)
+	}
+
+	return gitignore.NewMatcher(patterns)
+}
+
+// Apply marks the files of the diff to be summarized.
+func (f *DiffFilter) Apply(d *Diff) error {
+	var attrs attributeStack
+	if f.attributes {
+		paths := make([]string, len(d.Files))
+		for i, fd := range d.Files {
+			paths[i] = fd.Path()
+		}
+
+		var err error
+		if attrs, err = f.loadAttributes(paths); err != nil {
+			return err
+		}
+	}
+
+	for _, fd := range d.Files {
+		if reason := f.summarizeReason(fd.Path(), attrs); reason != "" {
+			Debugf("Summarizing diff of %s: %s", fd.Path(), reason)
+			fd.Summarized = true
+		}
+	}
+
+	return nil
+}
+
+// summarizeReason tells why the diff of the file should be summarized, if it should be.
+func (f *DiffFilter) summarizeReason(path string, attrs attributeStack) string {
+	parts := strings.Split(path, "/")
+
+	if f.include.Match(parts, false) {
+		return ""
+	}
+	if f.exclude.Match(parts, false) {
+		return "excluded"
+	}
+
+	results := attrs.match(parts)
+	isTrue := func(name string) bool {
+		attr, ok := results[name]
+		return ok && (attr.IsSet() || (attr.IsValueSet() && attr.Value() == "true"))
+	}
+
+	switch {
+	case isTrue(attrLinguistGenerated):
+		return "generated"
+	case isTrue(attrLinguistVendored):
+		return "vendored"
+	case isTrue(attrBinary):
+		return "binary"
+	}
+	if attr, ok := results[attrDiff]; ok && attr.IsUnset() {
+		return "-diff"
+	}
+
+	return ""
+}
+
+// loadAttributes reads .gitattributes of the root and of the directories of the paths from
+// the index, so unstaged changes of them don't apply, and $GIT_DIR/info/attributes.
+func (f *DiffFilter) loadAttributes(paths []string) (attributeStack, error) {
+	idx, err := f.repo.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := [][]string{{}}
+	seen := map[string]bool{"": true}
+	for _, path := range paths {
+		parts := strings.Split(path, "/")
+		for i := 1; i < len(parts); i++ {
+			if dir := strings.Join(parts[:i], "/"); !seen[dir] {
+				seen[dir] = true
+				dirs = append(dirs, parts[:i])
+			}
+		}
+	}
+
+	// Deeper files take precedence.
+	slices.SortStableFunc(dirs, func(a, b []string) int {
+		return len(a) - len(b)
+	})
+
+	var stack attributeStack
+	for _, dir := range dirs {
+		attrs, err := f.readIndexAttributes(idx, dir)
+		if err != nil {
+			return nil, fmt.Errorf("read .gitattributes of %q: %w", strings.Join(dir, "/"), err)
+		}
+		stack = append(stack, attrs...)
+	}
+
+	if f.gitDir != nil {
+		// Not ReadAttributesFile, the patterns of info/attributes are relative to the root.
+		info, err := f.gitDir.Open(f.gitDir.Join("info", "attributes"))
+		if err == nil {
+			defer info.Close()
+
+			attrs, err := gitattributes.ReadAttributes(info, nil, true)
+			if err != nil {
+				return nil, fmt.Errorf("read info/attributes: %w", err)
+			}
+			stack = append(stack, attrs...)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	return stack, nil
+}
+
+// readIndexAttributes reads the staged .gitattributes of the directory, if there is one.
+func (f *DiffFilter) readIndexAttributes(idx *index.Index, dir []string) ([]gitattributes.MatchAttribute, error) {
+	entry, err := idx.Entry(path.Join(append(slices.Clone(dir), ".gitattributes")...))
+	if errors.Is(err, index.ErrEntryNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	blob, err := f.repo.BlobObject(entry.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := blob.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return gitattributes.ReadAttributes(r, dir, len(dir) == 0)
+}
+
+// attributeStack holds gitattributes in the order of increasing priority.
+//
+// gitattributes.Matcher isn't used, as it lets the patterns of lower priority win.
+type attributeStack []gitattributes.MatchAttribute
+
+// match returns the attributes of the path. Macros aren't expanded.
+func (s attributeStack) match(path []string) map[string]gitattributes.Attribute {
+	results := make(map[string]gitattributes.Attribute)
+	for _, ma := range s {
+		if ma.Pattern == nil || !ma.Pattern.Match(path) {
+			continue
+		}
+		for _, attr := range ma.Attributes {
+			results[attr.Name()] = attr
+		}
+	}
+
+	return results
+}
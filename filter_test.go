@@ -0,0 +1,125 @@
+package llame
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (r *memRepo) filteredDiff(base *object.Tree, opts DiffOptions) string {
+	d, err := ParseDiff(r.diff(base, opts))
+	require.NoError(r.t, err)
+
+	filter, err := NewDiffFilter(r.repo, opts)
+	require.NoError(r.t, err)
+	require.NoError(r.t, filter.Apply(d))
+
+	return d.String()
+}
+
+func TestDiffFilter(t *testing.T) {
+	t.Run("exclude and include", func(t *testing.T) {
+		r := newMemRepo(t)
+		r.stage("main.go", "package main\n")
+		r.stage("go.sum", numberedLines(1, 40))
+		r.stage("vendor/github.com/pkg/errors/errors.go", "package errors\n")
+		r.stage("web/package-lock.json", "{}\n")
+
+		d := r.filteredDiff(nil, DefaultDiffOptions())
+		assert.Contains(t, d, "diff --git a/main.go b/main.go\n")
+		assert.Contains(t, d, "+package main\n")
+		assert.Contains(t, d, "go.sum: 40 lines changed\n")
+		assert.Contains(t, d, "\nvendor/github.com/pkg/errors/errors.go: 1 line changed\n")
+		assert.Contains(t, d, "\nweb/package-lock.json: 1 line changed\n")
+		assert.NotContains(t, d, "line 1\n")
+		assert.NotContains(t, d, "package errors")
+
+		opts := DefaultDiffOptions()
+		opts.Include = []string{"go.sum"}
+		opts.Exclude = append(opts.Exclude, "*.go")
+		d = r.filteredDiff(nil, opts)
+		assert.Contains(t, d, "diff --git a/go.sum b/go.sum\n")
+		assert.Contains(t, d, "\nmain.go: 1 line changed\n")
+	})
+
+	t.Run("attributes", func(t *testing.T) {
+		r := newMemRepo(t)
+		r.stage(".gitattributes", "openapi.yaml linguist-generated\n*.pb.go linguist-generated=true\n*.csv -diff\n")
+		r.stage("api/.gitattributes", "handwritten.pb.go -linguist-generated\nthird_party/** linguist-vendored\n")
+		r.stage("openapi.yaml", numberedLines(1, 22))
+		r.stage("api/service.pb.go", "package api\n")
+		r.stage("api/handwritten.pb.go", "package api\n")
+		r.stage("api/third_party/lib.go", "package lib\n")
+		r.stage("data.csv", "a,b\n1,2\n")
+
+		d := r.filteredDiff(nil, DefaultDiffOptions())
+		assert.Contains(t, d, "\nopenapi.yaml: 22 lines changed\n")
+		assert.Contains(t, d, "\napi/service.pb.go: 1 line changed\n")
+		assert.Contains(t, d, "diff --git a/api/handwritten.pb.go b/api/handwritten.pb.go\n")
+		assert.Contains(t, d, "\napi/third_party/lib.go: 1 line changed\n")
+		assert.Contains(t, d, "\ndata.csv: 2 lines changed\n")
+		assert.Contains(t, d, "diff --git a/.gitattributes b/.gitattributes\n")
+
+		opts := DefaultDiffOptions()
+		opts.Attributes = false
+		d = r.filteredDiff(nil, opts)
+		assert.Equal(t, 7, strings.Count(d, "diff --git"))
+	})
+
+	t.Run("staged attributes", func(t *testing.T) {
+		// Generated files are marked in .gitattributes, e.g. by the tool generating them.
+		r := newMemRepo(t)
+		r.stage(".gitattributes", "openapi.yaml linguist-generated\n")
+		base := r.commit()
+
+		r.stage("openapi.yaml", numberedLines(1, 22000))
+		r.stage("main.go", "package main\n")
+
+		// Unstaged changes of .gitattributes don't apply to the staged files.
+		require.NoError(t, util.WriteFile(r.workTree.Filesystem, ".gitattributes", []byte("*.go linguist-generated\n"), 0o644))
+
+		d := r.filteredDiff(base, DefaultDiffOptions())
+		assert.Contains(t, d, "\nopenapi.yaml: 22000 lines changed\n")
+		assert.Contains(t, d, "+package main\n")
+		assert.NotContains(t, d, "line 1\n")
+	})
+
+	t.Run("binary and renamed files", func(t *testing.T) {
+		r := newMemRepo(t)
+		r.stage("go.sum", numberedLines(1, 10))
+		base := r.commit()
+
+		r.remove("go.sum")
+		r.stage("tools/go.sum", numberedLines(1, 10))
+		r.stage("vendor/logo.png", "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+		d := r.filteredDiff(base, DefaultDiffOptions())
+		assert.Equal(t, "tools/go.sum: 0 lines changed\nvendor/logo.png: binary file changed\n", d)
+	})
+}
+
+func TestGitDiffStagedInfoAttributes(t *testing.T) {
+	repo, dir := initTestRepo(t)
+	stageFile(t, repo, dir, "schema.sql", "CREATE TABLE t (id INT);\n")
+	stageFile(t, repo, dir, "main.go", "package main\n")
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, ".git", "info"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".git", "info", "attributes"), []byte("*.sql linguist-generated\n"), 0o644))
+
+	for _, engine := range []DiffEngine{DiffEngineNative, DiffEngineGit} {
+		opts := DefaultDiffOptions()
+		opts.Engine = engine
+
+		d, err := GitDiffStaged(context.Background(), opts)
+		require.NoError(t, err)
+		assert.Contains(t, d.String(), "+package main\n", engine)
+		assert.Contains(t, d.String(), "\nschema.sql: 1 line changed\n", engine)
+	}
+}
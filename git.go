@@ -1,15 +1,20 @@
 package llame
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 )
 
 // The 50/72 rule is a guideline for writing clear and concise Git commit messages:
@@ -20,21 +25,144 @@ const (
 
 var NoStagedFilesErr = errors.New("no staged files")
 
+// LintError describes why a commit message doesn't follow the conventions.
+type LintError struct {
+	Reason string
+}
+
+func (e *LintError) Error() string {
+	return "bad commit message: " + e.Reason
+}
+
+// LintCommitMsg checks that the message has a non-empty subject which isn't longer than
+// GitCommiBodyCharsMax characters and is separated from the body with a blank line.
+func LintCommitMsg(msg string) error {
+	lines := strings.Split(strings.TrimSpace(msg), "\n")
+
+	subject := strings.TrimSpace(lines[0])
+	if subject == "" {
+		return &LintError{Reason: "empty subject"}
+	}
+
+	if n := utf8.RuneCountInString(subject); n > GitCommiBodyCharsMax {
+		return &LintError{Reason: fmt.Sprintf("subject is %d characters long, max is %d", n, GitCommiBodyCharsMax)}
+	}
+
+	if len(lines) > 1 && strings.TrimSpace(lines[1]) != "" {
+		return &LintError{Reason: "subject must be separated from the body with a blank line"}
+	}
+
+	return nil
+}
+
+var listItemRe = regexp.MustCompile(`^\s*(?:[-*+]|\d+[.)])\s+`)
+
+// WrapCommitMsg wraps the paragraphs of the body at width characters, list items are wrapped
+// with a hanging indent. The subject, trailers, indented lines (e.g. code) and words longer
+// than the width (e.g. URLs) are kept as is.
+func WrapCommitMsg(msg string, width int) string {
+	lines := strings.Split(msg, "\n")
+	wrapped := []string{lines[0]}
+
+	var paragraph []string
+	flush := func() {
+		wrapped = append(wrapped, wrapParagraph(paragraph, width)...)
+		paragraph = nil
+	}
+	for _, line := range lines[1:] {
+		if strings.TrimSpace(line) == "" {
+			flush()
+			wrapped = append(wrapped, line)
+			continue
+		}
+		paragraph = append(paragraph, line)
+	}
+	flush()
+
+	return strings.Join(wrapped, "\n")
+}
+
+func wrapParagraph(lines []string, width int) []string {
+	if !slices.ContainsFunc(lines, func(line string) bool { return !trailerRe.MatchString(line) }) {
+		return lines
+	}
+
+	var (
+		wrapped       []string
+		words         []string
+		first, indent string // Prefixes of the first and the rest of the lines
+		inItem        bool
+	)
+	flush := func() {
+		if len(words) > 0 {
+			wrapped = append(wrapped, wrapWords(words, width, first, indent)...)
+		}
+		words = nil
+	}
+	for _, line := range lines {
+		if marker := listItemRe.FindString(line); marker != "" {
+			flush()
+			first, indent, inItem = marker, strings.Repeat(" ", len(marker)), true
+			line = line[len(marker):]
+		} else if !inItem && (strings.HasPrefix(line, "\t") || strings.HasPrefix(line, "    ")) {
+			flush()
+			wrapped = append(wrapped, line)
+			continue
+		}
+		words = append(words, strings.Fields(line)...)
+	}
+	flush()
+
+	return wrapped
+}
+
+func wrapWords(words []string, width int, first, indent string) []string {
+	var lines []string
+	line := first + words[0]
+	for _, word := range words[1:] {
+		if utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) > width {
+			lines = append(lines, line)
+			line = indent + word
+		} else {
+			line += " " + word
+		}
+	}
+
+	return append(lines, line)
+}
+
+// EmptyTreeHash is the hash of the tree without files, git knows it even if it isn't stored.
+const EmptyTreeHash = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
 // GitDiffStaged gets a diff for all staged files (if only called with context) or for the specified ones.
-func GitDiffStaged(ctx context.Context, files ...string) ([]byte, error) {
-	cmd := exec.CommandContext(ctx, "git", append([]string{"diff", "--staged", "HEAD"}, files...)...)
-	var changes bytes.Buffer
-	cmd.Stdout = &changes
-	if err := cmd.Run(); err != nil {
+// Before the initial commit, all the staged files are new, so they are diffed against the empty tree.
+func GitDiffStaged(ctx context.Context, opts DiffOptions, files ...string) (*Diff, error) {
+	repo, err := NewGitRepo()
+	if err != nil {
 		return nil, err
 	}
 
-	diffs := changes.Bytes()
-	if len(diffs) == 0 {
-		return nil, NoStagedFilesErr
+	unborn, err := HeadUnborn(repo)
+	if err != nil {
+		return nil, err
 	}
 
-	return diffs, nil
+	rev := "HEAD"
+	if unborn {
+		rev = EmptyTreeHash
+	}
+
+	return GitDiffStagedFrom(ctx, rev, opts, files...)
+}
+
+// HeadUnborn reports whether HEAD points to a branch without commits, as in a just initialized repository.
+func HeadUnborn(repo *git.Repository) (bool, error) {
+	_, err := repo.Head()
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return true, nil
+	}
+
+	return false, err
 }
 
 type GitFiles struct {
@@ -69,6 +197,21 @@ func NewGitRepo() (*git.Repository, error) {
 	return repo, nil
 }
 
+// RepoRoot returns the root directory of the repository's worktree.
+func RepoRoot() (string, error) {
+	repo, err := NewGitRepo()
+	if err != nil {
+		return "", err
+	}
+
+	workTree, err := repo.Worktree()
+	if err != nil {
+		return "", err
+	}
+
+	return workTree.Filesystem.Root(), nil
+}
+
 func MustGitStatus() string {
 	repo, err := NewGitRepo()
 	if err != nil {
@@ -118,7 +261,10 @@ func stagedFiles(repo *git.Repository) (*GitFiles, error) {
 	}, nil
 }
 
-func GitCommit(commitMsg string) error {
+// GitCommit commits the staged changes. Since go-git doesn't run hooks, the pre-commit,
+// prepare-commit-msg, commit-msg and post-commit hooks are run here the way git does.
+// The commit is aborted if any hook but post-commit fails.
+func GitCommit(ctx context.Context, commitMsg string, opts CommitOptions) error {
 	repo, err := NewGitRepo()
 	if err != nil {
 		return err
@@ -129,10 +275,97 @@ func GitCommit(commitMsg string) error {
 		return err
 	}
 
-	// TODO: perhaps add some commit options
-	_, err = workTree.Commit(commitMsg, &git.CommitOptions{})
+	gitDir, err := GitDir(repo)
+	if err != nil {
+		return err
+	}
+
+	plan, err := PlanCommit(repo, opts)
+	if err != nil {
+		return err
+	}
+
+	if !opts.NoVerify {
+		if err := RunHook(ctx, repo, PreCommitHook, opts.HookOutput); err != nil {
+			return err
+		}
+	}
+
+	// Hooks may have changed the index.
+	if err := plan.checkNotEmpty(workTree); err != nil {
+		return err
+	}
+
+	if plan.SignOff {
+		commitMsg = AddSignOff(commitMsg, plan.Committer)
+	}
+
+	// Hooks read and edit the message in a file.
+	msgPath := filepath.Join(gitDir, "COMMIT_EDITMSG")
+	if err := os.WriteFile(msgPath, []byte(strings.TrimRight(commitMsg, "\n")+"\n"), 0o644); err != nil {
+		return err
+	}
+
+	// Like git, pass the amended commit as the source of the message.
+	hookArgs := []string{msgPath, CommitSourceMessage}
+	if plan.Amend {
+		head, err := headCommit(repo)
+		if err != nil {
+			return err
+		}
+		hookArgs = []string{msgPath, CommitSourceCommit, head.Hash.String()}
+	}
+
+	if err := RunHook(ctx, repo, PrepareCommitMsgHook, opts.HookOutput, hookArgs...); err != nil {
+		return err
+	}
+
+	if !opts.NoVerify {
+		if err := RunHook(ctx, repo, CommitMsgHook, opts.HookOutput, msgPath); err != nil {
+			return err
+		}
+	}
+
+	content, err := os.ReadFile(msgPath)
+	if err != nil {
+		return err
+	}
+
+	commitMsg = cleanupCommitMsg(string(content))
+	if commitMsg == "" {
+		return errors.New("aborting commit due to empty commit message")
+	}
+
+	if _, err = workTree.Commit(commitMsg, plan.gitOptions()); err != nil {
+		return err
+	}
+
+	// Like git, ignore the exit status of post-commit: the commit is already made.
+	if err := RunHook(ctx, repo, PostCommitHook, opts.HookOutput); err != nil {
+		Errorf("%s", err)
+	}
+
+	return nil
+}
+
+// cleanupCommitMsg strips trailing whitespace of every line, collapses consecutive
+// empty lines and removes leading and trailing ones (git's "whitespace" cleanup mode).
+func cleanupCommitMsg(msg string) string {
+	var lines []string
+	for _, line := range strings.Split(msg, "\n") {
+		line = strings.TrimRightFunc(line, unicode.IsSpace)
+		if line == "" && (len(lines) == 0 || lines[len(lines)-1] == "") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	msg = strings.TrimRight(strings.Join(lines, "\n"), "\n")
+	if msg == "" {
+		return ""
+	}
 
-	return err
+	return msg + "\n"
 }
 
 // TODO: rm
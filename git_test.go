@@ -0,0 +1,93 @@
+package llame
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLintCommitMsg(t *testing.T) {
+	for _, tc := range []struct {
+		msg string
+		ok  bool
+	}{
+		{msg: "Add commit message linting", ok: true},
+		{msg: "  Add linting\n\nExplain why.\n", ok: true},
+		{msg: "", ok: false},
+		{msg: strings.Repeat("a", GitCommiBodyCharsMax+1), ok: false},
+		{msg: "Subject\nBody right after subject", ok: false},
+	} {
+		err := LintCommitMsg(tc.msg)
+		if tc.ok {
+			assert.NoError(t, err, tc.msg)
+		} else {
+			assert.Error(t, err, tc.msg)
+		}
+	}
+}
+
+func TestCleanupCommitMsg(t *testing.T) {
+	assert.Equal(t, "Subject\n\nBody\n", cleanupCommitMsg("\n\nSubject  \n\n\n\nBody\t\n\n"))
+	assert.Equal(t, "", cleanupCommitMsg(" \n\n"))
+}
+
+func TestGitDiffStagedInitialCommit(t *testing.T) {
+	repo, dir := initTestRepo(t)
+
+	unborn, err := HeadUnborn(repo)
+	require.NoError(t, err)
+	assert.True(t, unborn)
+
+	_, err = GitDiffStaged(context.Background(), DefaultDiffOptions())
+	assert.ErrorIs(t, err, NoStagedFilesErr)
+
+	stageFile(t, repo, dir, "README", "llame\n")
+	diff, err := GitDiffStaged(context.Background(), DefaultDiffOptions())
+	require.NoError(t, err)
+	assert.Contains(t, diff.String(), "new file mode 100644")
+	assert.Contains(t, diff.String(), "+llame")
+
+	require.NoError(t, GitCommit(context.Background(), "Initial commit", CommitOptions{}))
+	unborn, err = HeadUnborn(repo)
+	require.NoError(t, err)
+	assert.False(t, unborn)
+}
+
+func TestWrapCommitMsg(t *testing.T) {
+	msg := "Add a subject that is kept as is even though it is longer than the width\n" +
+		"\n" +
+		"The body explains what changed and why in prose, which is wrapped at the width.\n" +
+		"Lines of a paragraph are joined.\n" +
+		"\n" +
+		"- List items are wrapped with a hanging indent, so they stay readable\n" +
+		"- Short item\n" +
+		"  * Nested items too, with a https://example.com/a/very/long/url/that/isnt/broken\n" +
+		"\n" +
+		"    code stays\n" +
+		"\n" +
+		"Refs: PROJ-1\n" +
+		"Signed-off-by: llame <llame@example.com>"
+
+	assert.Equal(t, "Add a subject that is kept as is even though it is longer than the width\n"+
+		"\n"+
+		"The body explains what changed and why in prose,\n"+
+		"which is wrapped at the width. Lines of a\n"+
+		"paragraph are joined.\n"+
+		"\n"+
+		"- List items are wrapped with a hanging indent, so\n"+
+		"  they stay readable\n"+
+		"- Short item\n"+
+		"  * Nested items too, with a\n"+
+		"    https://example.com/a/very/long/url/that/isnt/broken\n"+
+		"\n"+
+		"    code stays\n"+
+		"\n"+
+		"Refs: PROJ-1\n"+
+		"Signed-off-by: llame <llame@example.com>", WrapCommitMsg(msg, 50))
+
+	assert.Equal(t, "Subject", WrapCommitMsg("Subject", 50))
+	assert.Equal(t, "Subject\n\nBody.\n", WrapCommitMsg("Subject\n\nBody.\n", 50))
+}
@@ -0,0 +1,385 @@
+package llame
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// HistoryOptions configure learning the commit style from the history of the repository.
+type HistoryOptions struct {
+	Depth    int      // Commits to learn from
+	Examples int      // Past messages given to the model as examples, besides the pinned ones
+	Pin      []string // Revisions of commits that are always given as examples
+	Exclude  []string // Revisions of commits that are never given as examples
+}
+
+// Options returns the history options set in config.
+func (c HistoryConfig) Options() HistoryOptions {
+	return HistoryOptions{
+		Depth:    c.Depth,
+		Examples: c.Examples,
+		Pin:      c.Pin,
+		Exclude:  c.Exclude,
+	}
+}
+
+const (
+	// historyExampleFilesMax limits the number of changed files listed for an example.
+	historyExampleFilesMax = 5
+	// styleMajority is the share of the commits having a trait for it to be a convention.
+	styleMajority = 0.6
+)
+
+// CommitStyle is the style of the commit messages of a repository.
+type CommitStyle struct {
+	Profile  StyleProfile
+	Examples []CommitExample // Pinned first, then the most recent ones
+}
+
+// CommitExample is a past commit, given to the model as an example of the style.
+type CommitExample struct {
+	Hash    plumbing.Hash
+	Subject string
+	Files   []string // Changed files
+}
+
+// StyleProfile describes the subjects of past commits.
+type StyleProfile struct {
+	Commits       int
+	AvgSubjectLen int
+
+	// Shares of the commits with the traits, from 0 to 1.
+	Capitalized    float64
+	TrailingPeriod float64
+	Conventional   float64
+	TicketPrefix   float64
+	Gitmoji        float64
+
+	TicketExample string   // A prefix like "ABC-123:"
+	Types         []string // Of Conventional Commits, the most common first
+	Scopes        []string
+}
+
+var (
+	ticketPrefixRe = regexp.MustCompile(`^\[?[A-Z][A-Z0-9]+-\d+\]?:?\s+`)
+	gitmojiCodeRe  = regexp.MustCompile(`^:[a-z0-9_+-]+:\s*`)
+)
+
+// LearnCommitStyle walks the recent commits reachable from HEAD, skipping merges and the ones
+// with subjects generated by git, e.g. "fixup! ...". The style is empty if there are no commits yet.
+func LearnCommitStyle(repo *git.Repository, opts HistoryOptions) (*CommitStyle, error) {
+	style := &CommitStyle{}
+
+	unborn, err := HeadUnborn(repo)
+	if err != nil || unborn {
+		return style, err
+	}
+
+	excluded := make(map[plumbing.Hash]bool)
+	for _, rev := range opts.Exclude {
+		hash, err := repo.ResolveRevision(plumbing.Revision(rev))
+		if err != nil {
+			return nil, fmt.Errorf("resolve excluded example %q: %w", rev, err)
+		}
+		excluded[*hash] = true
+	}
+
+	for _, rev := range opts.Pin {
+		hash, err := repo.ResolveRevision(plumbing.Revision(rev))
+		if err != nil {
+			return nil, fmt.Errorf("resolve pinned example %q: %w", rev, err)
+		}
+
+		commit, err := repo.CommitObject(*hash)
+		if err != nil {
+			return nil, err
+		}
+
+		example, err := newCommitExample(commit)
+		if err != nil {
+			return nil, err
+		}
+		style.Examples = append(style.Examples, example)
+		excluded[*hash] = true
+	}
+
+	iter, err := repo.Log(&git.LogOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var commits []*object.Commit
+	err = iter.ForEach(func(c *object.Commit) error {
+		if len(commits) >= opts.Depth {
+			return storer.ErrStop
+		}
+		if c.NumParents() <= 1 && isExampleSubject(commitSubject(c.Message)) {
+			commits = append(commits, c)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	style.Profile = newStyleProfile(commits)
+
+	var candidates []*object.Commit
+	for _, c := range commits {
+		if !excluded[c.Hash] {
+			candidates = append(candidates, c)
+		}
+	}
+
+	// Representative commits share the conventions of the most, the recent ones are preferred.
+	slices.SortStableFunc(candidates, func(a, b *object.Commit) int {
+		return cmp.Compare(style.Profile.deviation(commitSubject(a.Message)), style.Profile.deviation(commitSubject(b.Message)))
+	})
+	for _, c := range candidates[:min(len(candidates), opts.Examples)] {
+		example, err := newCommitExample(c)
+		if err != nil {
+			return nil, err
+		}
+		style.Examples = append(style.Examples, example)
+	}
+
+	return style, nil
+}
+
+func commitSubject(msg string) string {
+	subject, _, _ := strings.Cut(strings.TrimSpace(msg), "\n")
+	return strings.TrimSpace(subject)
+}
+
+// isExampleSubject filters out the subjects generated by git, which don't show the style.
+func isExampleSubject(subject string) bool {
+	for _, prefix := range []string{"fixup!", "squash!", "amend!", "Merge ", "Revert \""} {
+		if strings.HasPrefix(subject, prefix) {
+			return false
+		}
+	}
+
+	return subject != ""
+}
+
+func newCommitExample(c *object.Commit) (CommitExample, error) {
+	example := CommitExample{Hash: c.Hash, Subject: commitSubject(c.Message)}
+
+	tree, err := c.Tree()
+	if err != nil {
+		return example, err
+	}
+
+	parentTree := &object.Tree{}
+	if c.NumParents() > 0 {
+		parent, err := c.Parent(0)
+		if err != nil {
+			return example, err
+		}
+		if parentTree, err = parent.Tree(); err != nil {
+			return example, err
+		}
+	}
+
+	// Only the names of the files are compared, which is much faster than the contents.
+	changes, err := object.DiffTree(parentTree, tree)
+	if err != nil {
+		return example, err
+	}
+	for _, change := range changes {
+		name := change.To.Name
+		if name == "" {
+			name = change.From.Name
+		}
+		example.Files = append(example.Files, name)
+	}
+
+	return example, nil
+}
+
+// Changes describes the changed files of the example, e.g. "Changed files: main.go, go.mod".
+func (e CommitExample) Changes() string {
+	if len(e.Files) == 0 {
+		return "No changed files"
+	}
+
+	files := e.Files
+	var more string
+	if len(files) > historyExampleFilesMax {
+		more = fmt.Sprintf(" and %d more", len(files)-historyExampleFilesMax)
+		files = files[:historyExampleFilesMax]
+	}
+
+	return "Changed files: " + strings.Join(files, ", ") + more
+}
+
+func newStyleProfile(commits []*object.Commit) StyleProfile {
+	p := StyleProfile{Commits: len(commits)}
+	if len(commits) == 0 {
+		return p
+	}
+
+	var subjectLen, capitalized, period, conventional, ticket, gitmoji int
+	types, scopes := make(map[string]int), make(map[string]int)
+	for _, c := range commits {
+		subject := commitSubject(c.Message)
+		subjectLen += utf8.RuneCountInString(subject)
+
+		if strings.HasSuffix(subject, ".") {
+			period++
+		}
+
+		if prefix := ticketPrefixRe.FindString(subject); prefix != "" {
+			ticket++
+			p.TicketExample = cmp.Or(p.TicketExample, strings.TrimSpace(prefix))
+			subject = subject[len(prefix):]
+		}
+
+		if hasGitmoji(subject) {
+			gitmoji++
+		}
+
+		if s, err := ParseConventionalSubject(subject); err == nil {
+			conventional++
+			types[s.Type]++
+			for _, scope := range s.Scopes {
+				scopes[scope]++
+			}
+			subject = s.Description
+		}
+
+		if r, _ := utf8.DecodeRuneInString(stripGitmoji(subject)); unicode.IsUpper(r) {
+			capitalized++
+		}
+	}
+
+	n := float64(len(commits))
+	p.AvgSubjectLen = subjectLen / len(commits)
+	p.Capitalized = float64(capitalized) / n
+	p.TrailingPeriod = float64(period) / n
+	p.Conventional = float64(conventional) / n
+	p.TicketPrefix = float64(ticket) / n
+	p.Gitmoji = float64(gitmoji) / n
+	p.Types = mostCommon(types)
+	p.Scopes = mostCommon(scopes)
+
+	return p
+}
+
+// mostCommon returns up to 5 keys with the highest counts.
+func mostCommon(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		return cmp.Or(cmp.Compare(counts[b], counts[a]), cmp.Compare(a, b))
+	})
+
+	return keys[:min(len(keys), 5)]
+}
+
+func hasGitmoji(subject string) bool {
+	if gitmojiCodeRe.MatchString(subject) {
+		return true
+	}
+
+	r, _ := utf8.DecodeRuneInString(subject)
+	return isEmoji(r)
+}
+
+func stripGitmoji(subject string) string {
+	if code := gitmojiCodeRe.FindString(subject); code != "" {
+		return subject[len(code):]
+	}
+
+	if r, size := utf8.DecodeRuneInString(subject); isEmoji(r) {
+		// Emojis may be followed by a variation selector.
+		return strings.TrimLeftFunc(subject[size:], func(r rune) bool {
+			return unicode.IsSpace(r) || r == '\uFE0F'
+		})
+	}
+
+	return subject
+}
+
+func isEmoji(r rune) bool {
+	return (r >= 0x1f300 && r <= 0x1faff) || (r >= 0x2600 && r <= 0x27bf)
+}
+
+// deviation tells how much the subject differs from the profile, 0 if it follows all the conventions.
+func (p StyleProfile) deviation(subject string) int {
+	var d int
+	trait := func(share float64, has bool) {
+		if (share >= styleMajority && !has) || (share <= 1-styleMajority && has) {
+			d++
+		}
+	}
+
+	rest := subject
+	if prefix := ticketPrefixRe.FindString(rest); prefix != "" {
+		rest = rest[len(prefix):]
+	}
+	trait(p.TicketPrefix, rest != subject)
+	trait(p.Gitmoji, hasGitmoji(rest))
+	s, err := ParseConventionalSubject(rest)
+	trait(p.Conventional, err == nil)
+	if err == nil {
+		rest = s.Description
+	}
+	r, _ := utf8.DecodeRuneInString(stripGitmoji(rest))
+	trait(p.Capitalized, unicode.IsUpper(r))
+	trait(p.TrailingPeriod, strings.HasSuffix(subject, "."))
+
+	if n := utf8.RuneCountInString(subject); n < p.AvgSubjectLen/2 || n > p.AvgSubjectLen*3/2 {
+		d++
+	}
+
+	return d
+}
+
+// String describes the conventions for the model, it's empty if there are no commits.
+func (p StyleProfile) String() string {
+	if p.Commits == 0 {
+		return ""
+	}
+
+	conventions := []string{fmt.Sprintf("are about %d characters long", p.AvgSubjectLen)}
+	convention := func(share float64, does, doesNot string) {
+		switch {
+		case share >= styleMajority && does != "":
+			conventions = append(conventions, does)
+		case share <= 1-styleMajority && doesNot != "":
+			conventions = append(conventions, doesNot)
+		}
+	}
+
+	convention(p.TicketPrefix, fmt.Sprintf("start with a ticket ID like %q", p.TicketExample), "")
+	convention(p.Gitmoji, "start with a gitmoji", "")
+	if p.Conventional >= styleMajority {
+		c := "follow Conventional Commits"
+		if len(p.Types) > 0 {
+			c += " (common types: " + strings.Join(p.Types, ", ")
+			if len(p.Scopes) > 0 {
+				c += "; common scopes: " + strings.Join(p.Scopes, ", ")
+			}
+			c += ")"
+		}
+		conventions = append(conventions, c)
+	}
+	convention(p.Capitalized, "start with a capital letter", "start with a lowercase letter")
+	convention(p.TrailingPeriod, "end with a period", "don't end with a period")
+
+	return "Subjects of the commit messages in this repository " + strings.Join(conventions, ", ") + "."
+}
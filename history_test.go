@@ -0,0 +1,108 @@
+package llame
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (r *memRepo) commitMsg(msg string, files ...string) plumbing.Hash {
+	for _, f := range files {
+		r.stage(f, msg)
+	}
+
+	hash, err := r.workTree.Commit(msg, &git.CommitOptions{
+		Author:            &object.Signature{Name: "llame", Email: "llame@example.com", When: time.Now()},
+		AllowEmptyCommits: len(files) == 0,
+	})
+	require.NoError(r.t, err)
+
+	return hash
+}
+
+func exampleSubjects(examples []CommitExample) []string {
+	subjects := make([]string, len(examples))
+	for i, e := range examples {
+		subjects[i] = e.Subject
+	}
+	return subjects
+}
+
+func TestLearnCommitStyle(t *testing.T) {
+	opts := HistoryOptions{Depth: 100, Examples: 3}
+
+	t.Run("unborn", func(t *testing.T) {
+		style, err := LearnCommitStyle(newMemRepo(t).repo, opts)
+		require.NoError(t, err)
+		assert.Equal(t, &CommitStyle{}, style)
+		assert.Empty(t, style.Profile.String())
+	})
+
+	t.Run("conventional", func(t *testing.T) {
+		r := newMemRepo(t)
+		initial := r.commitMsg("chore: initial commit", "README.md")
+		r.commitMsg("feat(cli): add the config command", "cmd/main.go", "config.go")
+		r.commitMsg("fixup! feat(cli): add the config command", "cmd/main.go")
+		r.commitMsg("Update stuff.", "main.go")
+		r.commitMsg("fix(cli): exit with an error code", "cmd/main.go")
+		r.commitMsg("docs: describe the config", "README.md")
+
+		style, err := LearnCommitStyle(r.repo, opts)
+		require.NoError(t, err)
+
+		p := style.Profile
+		assert.Equal(t, 5, p.Commits, "fixups are skipped")
+		assert.InDelta(t, 0.8, p.Conventional, 0.01)
+		assert.InDelta(t, 0.2, p.TrailingPeriod, 0.01)
+		assert.Zero(t, p.TicketPrefix)
+		assert.Equal(t, []string{"chore", "docs", "feat", "fix"}, p.Types)
+		assert.Equal(t, []string{"cli"}, p.Scopes)
+		assert.Equal(t, "Subjects of the commit messages in this repository are about 25 characters long, "+
+			"follow Conventional Commits (common types: chore, docs, feat, fix; common scopes: cli), "+
+			"start with a lowercase letter, don't end with a period.", p.String())
+
+		// Subjects following the conventions, the recent first.
+		assert.Equal(t, []string{"docs: describe the config", "fix(cli): exit with an error code", "feat(cli): add the config command"},
+			exampleSubjects(style.Examples))
+		assert.Equal(t, "Changed files: cmd/main.go", style.Examples[1].Changes())
+		assert.Equal(t, "Changed files: cmd/main.go, config.go", style.Examples[2].Changes())
+
+		opts := opts
+		opts.Pin = []string{initial.String()}
+		opts.Exclude = []string{"HEAD"}
+		style, err = LearnCommitStyle(r.repo, opts)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"chore: initial commit", "fix(cli): exit with an error code", "feat(cli): add the config command", "Update stuff."},
+			exampleSubjects(style.Examples))
+		assert.Equal(t, "Changed files: README.md", style.Examples[0].Changes())
+
+		opts.Pin = []string{"unknown"}
+		_, err = LearnCommitStyle(r.repo, opts)
+		assert.ErrorContains(t, err, `resolve pinned example "unknown"`)
+	})
+
+	t.Run("tickets", func(t *testing.T) {
+		r := newMemRepo(t)
+		r.commitMsg("ABC-1: Add the parser")
+		r.commitMsg("[ABC-2] Support comments")
+		r.commitMsg("ABC-3 Fix the crash on empty input")
+
+		style, err := LearnCommitStyle(r.repo, HistoryOptions{Depth: 2, Examples: 1})
+		require.NoError(t, err)
+		assert.Equal(t, 2, style.Profile.Commits)
+		assert.Equal(t, "Subjects of the commit messages in this repository are about 29 characters long, "+
+			`start with a ticket ID like "ABC-3", start with a capital letter, don't end with a period.`, style.Profile.String())
+		assert.Equal(t, []string{"ABC-3 Fix the crash on empty input"}, exampleSubjects(style.Examples))
+		assert.Equal(t, "No changed files", style.Examples[0].Changes())
+	})
+}
+
+func TestCommitExampleChanges(t *testing.T) {
+	e := CommitExample{Files: []string{"a", "b", "c", "d", "e", "f", "g"}}
+	assert.Equal(t, "Changed files: a, b, c, d, e and 2 more", e.Changes())
+}
@@ -0,0 +1,315 @@
+package llame
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+)
+
+const (
+	PreCommitHook        = "pre-commit"
+	PrepareCommitMsgHook = "prepare-commit-msg"
+	CommitMsgHook        = "commit-msg"
+	PostCommitHook       = "post-commit"
+
+	// chainedHookSuffix is appended to the name of a hook replaced by llame, which is then run by llame's hook.
+	chainedHookSuffix = ".llame-chained"
+	hookMarker        = "# Installed by llame."
+)
+
+var HookNotInstalledErr = errors.New("llame hook is not installed")
+
+// HookError is returned when a hook exits with a non-zero status.
+type HookError struct {
+	Hook string
+	Err  error
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("%s hook failed: %s", e.Hook, e.Err)
+}
+
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+// GitConfigOption returns the value of the option looking it up in the repository,
+// global and system configs (in that order), like git does.
+func GitConfigOption(repo *git.Repository, section, key string) (string, bool) {
+	local, err := repo.Config()
+	if err == nil {
+		if value, ok := rawOption(local, section, key); ok {
+			return value, true
+		}
+	}
+
+	for _, scope := range []config.Scope{config.GlobalScope, config.SystemScope} {
+		cfg, err := config.LoadConfig(scope)
+		if err != nil {
+			Debugf("Failed to load git config of scope %d: %s", scope, err)
+			continue
+		}
+
+		if value, ok := rawOption(cfg, section, key); ok {
+			return value, true
+		}
+	}
+
+	return "", false
+}
+
+func rawOption(cfg *config.Config, section, key string) (string, bool) {
+	if cfg.Raw == nil || !cfg.Raw.HasSection(section) {
+		return "", false
+	}
+
+	s := cfg.Raw.Section(section)
+	if !s.HasOption(key) {
+		return "", false
+	}
+
+	return s.Option(key), true
+}
+
+// GitDir returns the path to the .git directory of the repository.
+func GitDir(repo *git.Repository) (string, error) {
+	storage, ok := repo.Storer.(*filesystem.Storage)
+	if !ok {
+		return "", errors.New("repository isn't stored on the filesystem")
+	}
+
+	return storage.Filesystem().Root(), nil
+}
+
+// GitHooksDir returns the directory git runs hooks from, honoring core.hooksPath.
+func GitHooksDir(repo *git.Repository) (string, error) {
+	if hooksPath, ok := GitConfigOption(repo, "core", "hooksPath"); ok && hooksPath != "" {
+		if rest, ok := strings.CutPrefix(hooksPath, "~/"); ok {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", err
+			}
+			return filepath.Join(home, rest), nil
+		}
+
+		if filepath.IsAbs(hooksPath) {
+			return hooksPath, nil
+		}
+
+		// A relative path is relative to the directory where hooks are run, i.e. the worktree root.
+		workTree, err := repo.Worktree()
+		if err != nil {
+			return "", err
+		}
+
+		return filepath.Join(workTree.Filesystem.Root(), hooksPath), nil
+	}
+
+	gitDir, err := GitDir(repo)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(gitDir, "hooks"), nil
+}
+
+// InstallPrepareCommitMsgHook writes a prepare-commit-msg hook running `<executable> hook run`.
+// An existing hook not installed by llame is kept and run before llame's one.
+// It returns the path of the installed hook.
+func InstallPrepareCommitMsgHook(repo *git.Repository, executable string) (string, error) {
+	hooksDir, err := GitHooksDir(repo)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(hooksDir, 0o755); err != nil {
+		return "", err
+	}
+
+	hookPath := filepath.Join(hooksDir, PrepareCommitMsgHook)
+	chainedPath := hookPath + chainedHookSuffix
+
+	installed, err := isLlameHook(hookPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	if err == nil && !installed {
+		if _, err := os.Stat(chainedPath); err == nil {
+			return "", fmt.Errorf("can't keep the existing hook: %s already exists", chainedPath)
+		}
+
+		Debugf("Moving existing hook %s to %s", hookPath, chainedPath)
+		if err := os.Rename(hookPath, chainedPath); err != nil {
+			return "", err
+		}
+	}
+
+	if err := os.WriteFile(hookPath, []byte(prepareCommitMsgScript(executable)), 0o755); err != nil {
+		return "", err
+	}
+
+	return hookPath, nil
+}
+
+// UninstallPrepareCommitMsgHook removes llame's hook and restores the hook it replaced, if any.
+func UninstallPrepareCommitMsgHook(repo *git.Repository) (string, error) {
+	hooksDir, err := GitHooksDir(repo)
+	if err != nil {
+		return "", err
+	}
+
+	hookPath := filepath.Join(hooksDir, PrepareCommitMsgHook)
+
+	installed, err := isLlameHook(hookPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", HookNotInstalledErr
+		}
+		return "", err
+	}
+	if !installed {
+		return "", fmt.Errorf("%w: %s is not managed by llame", HookNotInstalledErr, hookPath)
+	}
+
+	if err := os.Remove(hookPath); err != nil {
+		return "", err
+	}
+
+	chainedPath := hookPath + chainedHookSuffix
+	if _, err := os.Stat(chainedPath); err == nil {
+		Debugf("Restoring hook %s from %s", hookPath, chainedPath)
+		if err := os.Rename(chainedPath, hookPath); err != nil {
+			return "", err
+		}
+	}
+
+	return hookPath, nil
+}
+
+// RunHook runs the hook with the given name from the hooks directory of the repository, if it's
+// present and executable. Like git, it runs the hook from the worktree root. Both stdout and stderr
+// of the hook are written to out (discarded if out is nil).
+func RunHook(ctx context.Context, repo *git.Repository, name string, out io.Writer, args ...string) error {
+	hooksDir, err := GitHooksDir(repo)
+	if err != nil {
+		return err
+	}
+
+	hookPath := filepath.Join(hooksDir, name)
+	info, err := os.Stat(hookPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if info.IsDir() || info.Mode().Perm()&0o111 == 0 {
+		Debugf("Hook %s isn't executable, skipping", hookPath)
+		return nil
+	}
+
+	workTree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+
+	gitDir, err := GitDir(repo)
+	if err != nil {
+		return err
+	}
+
+	if out == nil {
+		out = io.Discard
+	}
+
+	Debugf("Running hook %s %v", hookPath, args)
+
+	cmd := exec.CommandContext(ctx, hookPath, args...)
+	cmd.Dir = workTree.Filesystem.Root()
+	cmd.Env = append(os.Environ(), "GIT_INDEX_FILE="+filepath.Join(gitDir, "index"))
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Run(); err != nil {
+		return &HookError{Hook: name, Err: err}
+	}
+
+	return nil
+}
+
+func isLlameHook(path string) (bool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+
+	return strings.Contains(string(content), hookMarker), nil
+}
+
+// prepareCommitMsgScript runs the replaced hook (if any) and then llame. Failures of llame
+// are ignored, so a broken model server never blocks committing.
+func prepareCommitMsgScript(executable string) string {
+	return fmt.Sprintf(`#!/bin/sh
+%s Remove it with 'llame hook uninstall'.
+
+chained="$0%s"
+if [ -x "$chained" ]; then
+	"$chained" "$@" || exit $?
+fi
+
+%s hook run "$@" || true
+exit 0
+`, hookMarker, chainedHookSuffix, shellQuote(executable))
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Commit message sources passed by git as the second argument of prepare-commit-msg.
+const (
+	CommitSourceMessage  = "message"  // -m or -F
+	CommitSourceTemplate = "template" // -t or commit.template
+	CommitSourceMerge    = "merge"    // Merge commit or .git/MERGE_MSG exists
+	CommitSourceSquash   = "squash"   // .git/SQUASH_MSG exists
+	CommitSourceCommit   = "commit"   // -c, -C or --amend
+)
+
+// ShouldGenerateForSource reports whether a message should be generated for
+// a commit with the given source. Messages written by the user or by git itself are kept.
+func ShouldGenerateForSource(source string) bool {
+	switch source {
+	case "", CommitSourceTemplate:
+		return true
+	default:
+		return false
+	}
+}
+
+// PrependCommitMsg writes the message at the top of the commit message file,
+// keeping the rest of it (e.g. the status comments) intact.
+func PrependCommitMsg(path, msg string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	// Git's own template starts with an empty line for the message to be written at.
+	rest := strings.TrimPrefix(string(content), "\n")
+
+	return os.WriteFile(path, []byte(strings.TrimSpace(msg)+"\n\n"+rest), info.Mode().Perm())
+}
@@ -0,0 +1,184 @@
+package llame
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrepareCommitMsgHook(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+
+	hookPath := filepath.Join(dir, ".git", "hooks", PrepareCommitMsgHook)
+
+	t.Run("not installed", func(t *testing.T) {
+		_, err := UninstallPrepareCommitMsgHook(repo)
+		assert.ErrorIs(t, err, HookNotInstalledErr)
+	})
+
+	t.Run("existing hook is chained", func(t *testing.T) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(hookPath), 0o755))
+		foreign := "#!/bin/sh\necho foreign\n"
+		require.NoError(t, os.WriteFile(hookPath, []byte(foreign), 0o755))
+
+		path, err := InstallPrepareCommitMsgHook(repo, "/opt/it's/llame")
+		require.NoError(t, err)
+		assert.Equal(t, hookPath, path)
+
+		content, err := os.ReadFile(hookPath)
+		require.NoError(t, err)
+		assert.Contains(t, string(content), hookMarker)
+		assert.Contains(t, string(content), `'/opt/it'\''s/llame' hook run "$@"`)
+
+		chained, err := os.ReadFile(hookPath + chainedHookSuffix)
+		require.NoError(t, err)
+		assert.Equal(t, foreign, string(chained))
+
+		// Reinstalling must not chain llame's own hook.
+		_, err = InstallPrepareCommitMsgHook(repo, "llame")
+		require.NoError(t, err)
+		chained, err = os.ReadFile(hookPath + chainedHookSuffix)
+		require.NoError(t, err)
+		assert.Equal(t, foreign, string(chained))
+
+		_, err = UninstallPrepareCommitMsgHook(repo)
+		require.NoError(t, err)
+
+		restored, err := os.ReadFile(hookPath)
+		require.NoError(t, err)
+		assert.Equal(t, foreign, string(restored))
+		assert.NoFileExists(t, hookPath+chainedHookSuffix)
+
+		_, err = UninstallPrepareCommitMsgHook(repo)
+		assert.ErrorIs(t, err, HookNotInstalledErr)
+		require.NoError(t, os.Remove(hookPath))
+	})
+
+	t.Run("core.hooksPath", func(t *testing.T) {
+		cfg, err := repo.Config()
+		require.NoError(t, err)
+		cfg.Raw.Section("core").SetOption("hooksPath", ".githooks")
+		require.NoError(t, repo.SetConfig(cfg))
+
+		path, err := InstallPrepareCommitMsgHook(repo, "llame")
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(dir, ".githooks", PrepareCommitMsgHook), path)
+		assert.FileExists(t, path)
+	})
+}
+
+func TestShouldGenerateForSource(t *testing.T) {
+	assert.True(t, ShouldGenerateForSource(""))
+	assert.True(t, ShouldGenerateForSource(CommitSourceTemplate))
+	for _, source := range []string{CommitSourceMessage, CommitSourceMerge, CommitSourceSquash, CommitSourceCommit} {
+		assert.False(t, ShouldGenerateForSource(source), source)
+	}
+}
+
+func TestPrependCommitMsg(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "COMMIT_EDITMSG")
+	status := "# Please enter the commit message for your changes.\n# On branch master\n"
+	require.NoError(t, os.WriteFile(path, []byte("\n"+status), 0o644))
+
+	require.NoError(t, PrependCommitMsg(path, "  Add hook mode\n"))
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "Add hook mode\n\n"+status, string(content))
+}
+
+func TestRunHook(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+
+	hooksDir := filepath.Join(dir, ".git", "hooks")
+	require.NoError(t, os.MkdirAll(hooksDir, 0o755))
+
+	var out bytes.Buffer
+	require.NoError(t, RunHook(context.Background(), repo, PreCommitHook, &out), "missing hook")
+
+	require.NoError(t, os.WriteFile(filepath.Join(hooksDir, PreCommitHook), []byte("#!/bin/sh\nexit 1\n"), 0o644))
+	require.NoError(t, RunHook(context.Background(), repo, PreCommitHook, &out), "not executable hook")
+
+	script := "#!/bin/sh\necho \"args: $*\"\necho \"in $(pwd)\" >&2\nexit 3\n"
+	require.NoError(t, os.WriteFile(filepath.Join(hooksDir, CommitMsgHook), []byte(script), 0o755))
+
+	err = RunHook(context.Background(), repo, CommitMsgHook, &out, "MSG")
+	var hookErr *HookError
+	require.ErrorAs(t, err, &hookErr)
+	assert.Equal(t, CommitMsgHook, hookErr.Hook)
+
+	root, err := filepath.EvalSymlinks(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "args: MSG\nin "+root+"\n", out.String())
+}
+
+func TestGitCommitHooks(t *testing.T) {
+	repo, dir := initTestRepo(t)
+
+	hooksDir := filepath.Join(dir, ".git", "hooks")
+	require.NoError(t, os.MkdirAll(hooksDir, 0o755))
+	writeHook := func(name, script string) {
+		require.NoError(t, os.WriteFile(filepath.Join(hooksDir, name), []byte("#!/bin/sh\n"+script), 0o755))
+	}
+	writeHook(PreCommitHook, "echo 'lint failed'\nexit 1\n")
+	writeHook(CommitMsgHook, "printf '\\nRefs: #1\\n' >> \"$1\"\n")
+	writeHook(PostCommitHook, "echo done\n")
+
+	stageFile(t, repo, dir, "file", "content\n")
+
+	var out bytes.Buffer
+	err := GitCommit(context.Background(), "Add file", CommitOptions{HookOutput: &out})
+	var hookErr *HookError
+	require.ErrorAs(t, err, &hookErr)
+	assert.Equal(t, PreCommitHook, hookErr.Hook)
+	assert.Equal(t, "lint failed\n", out.String())
+
+	_, err = repo.Head()
+	assert.Error(t, err, "nothing must be committed")
+
+	out.Reset()
+	require.NoError(t, GitCommit(context.Background(), "Add file", CommitOptions{NoVerify: true, HookOutput: &out}))
+	assert.Equal(t, "done\n", out.String())
+
+	head, err := repo.Head()
+	require.NoError(t, err)
+	commit, err := repo.CommitObject(head.Hash())
+	require.NoError(t, err)
+	assert.Equal(t, "Add file\n", commit.Message, "commit-msg is skipped with NoVerify")
+
+	require.NoError(t, os.Remove(filepath.Join(hooksDir, PreCommitHook)))
+	stageFile(t, repo, dir, "file", "changed\n")
+
+	require.NoError(t, GitCommit(context.Background(), "Change file", CommitOptions{}))
+
+	head, err = repo.Head()
+	require.NoError(t, err)
+	commit, err = repo.CommitObject(head.Hash())
+	require.NoError(t, err)
+	assert.Equal(t, "Change file\n\nRefs: #1\n", commit.Message)
+
+	// prepare-commit-msg is told where the message comes from.
+	sourcePath := filepath.Join(dir, ".git", "source")
+	writeHook(PrepareCommitMsgHook, "echo \"$2 $3\" > '"+sourcePath+"'\n")
+	stageFile(t, repo, dir, "file", "changed again\n")
+	require.NoError(t, GitCommit(context.Background(), "Change file again", CommitOptions{}))
+	source, err := os.ReadFile(sourcePath)
+	require.NoError(t, err)
+	assert.Equal(t, CommitSourceMessage+" \n", string(source))
+
+	amended := headCommitT(t, repo)
+	require.NoError(t, GitCommit(context.Background(), "Change file twice", CommitOptions{Amend: true}))
+	source, err = os.ReadFile(sourcePath)
+	require.NoError(t, err)
+	assert.Equal(t, CommitSourceCommit+" "+amended.Hash.String()+"\n", string(source))
+}
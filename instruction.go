@@ -0,0 +1,222 @@
+package llame
+
+import (
+	"bytes"
+	"cmp"
+	"embed"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+//go:embed templates/*.tmpl
+var defaultTemplates embed.FS
+
+// Names of the templates of the prompt given to the model.
+const (
+	SystemTemplateName  = "system"
+	SubjectTemplateName = "subject.tmpl"
+	BodyTemplateName    = "body.tmpl"
+)
+
+// PromptLimits are the lengths the model is asked to keep the message within.
+type PromptLimits struct {
+	SubjectChars int
+	BodyWidth    int
+}
+
+// PromptData is what the instruction templates are rendered with.
+type PromptData struct {
+	Diff     string   // The staged changes, empty if there are none
+	DiffStat string   // As of "git diff --stat"
+	Files    []string // Paths of the changed files
+	Language string   // Of the most changed files, empty if unknown
+	// Secrets replaced with placeholders in the diff, see RedactDiff. Set by the caller.
+	Redactions []Redaction
+
+	Branch  string // Empty if HEAD is detached
+	Tickets TicketRefs
+	PrevMsg string // Message of the amended commit
+	Initial bool   // The commit is the first one of the repository
+
+	PromptStyle  PromptStyle
+	Conventional *ConventionalOptions // Nil unless the prompt style is conventional
+	Hints        ConventionalHints
+	BodyFormat   BodyFormat
+
+	History       *CommitStyle // Nil if learning from the history is disabled
+	StyleHint     string       // Conventions of the history, e.g. "Subjects ... are about 40 characters long."
+	RecentCommits []string     // Subjects of the example commits
+
+	Limits PromptLimits
+}
+
+// NewPromptData describes the changes, the rest of the data is filled by the caller.
+func NewPromptData(d *Diff) PromptData {
+	data := PromptData{
+		DiffStat: d.Stat(80),
+		Limits:   PromptLimits{SubjectChars: GitCommitSubjectCharsMin, BodyWidth: GitCommiBodyCharsMax},
+	}
+	if diff := d.String(); strings.TrimSpace(diff) != "" {
+		data.Diff = diff
+	}
+	if d == nil {
+		return data
+	}
+
+	changes := make(map[string]int)
+	for _, f := range d.Files {
+		data.Files = append(data.Files, f.Path())
+		if lang := f.Language(); lang != "" {
+			changes[lang] += f.Added + f.Deleted + 1
+		}
+	}
+	if len(changes) > 0 {
+		data.Language = slices.MaxFunc(slices.Sorted(maps.Keys(changes)), func(a, b string) int {
+			return cmp.Compare(changes[a], changes[b])
+		})
+	}
+
+	return data
+}
+
+// SetHistory adds the style learned from the history.
+func (d *PromptData) SetHistory(style *CommitStyle) {
+	d.History = style
+	d.StyleHint = style.Profile.String()
+	d.RecentCommits = nil
+	for _, example := range style.Examples {
+		d.RecentCommits = append(d.RecentCommits, example.Subject)
+	}
+}
+
+// escaped returns a copy of the data with the text coming from the repository escaped,
+// e.g. a diff of a prompt format mustn't end the turn of the user.
+func (d PromptData) escaped(escape func(string) string) PromptData {
+	escapeAll := func(ss []string) []string {
+		if ss == nil {
+			return nil
+		}
+
+		escaped := make([]string, 0, len(ss))
+		for _, s := range ss {
+			escaped = append(escaped, escape(s))
+		}
+		return escaped
+	}
+
+	d.Diff = escape(d.Diff)
+	d.DiffStat = escape(d.DiffStat)
+	d.Files = escapeAll(d.Files)
+	d.Branch = escape(d.Branch)
+	d.PrevMsg = escape(d.PrevMsg)
+	d.StyleHint = escape(d.StyleHint)
+	d.RecentCommits = escapeAll(d.RecentCommits)
+	d.Hints.Scopes = escapeAll(d.Hints.Scopes)
+
+	if d.History != nil {
+		history := *d.History
+		history.Examples = make([]CommitExample, 0, len(d.History.Examples))
+		for _, example := range d.History.Examples {
+			example.Subject = escape(example.Subject)
+			example.Files = escapeAll(example.Files)
+			history.Examples = append(history.Examples, example)
+		}
+		d.History = &history
+	}
+
+	return d
+}
+
+// PromptTemplates are text/template templates of the instructions for subjects and full messages.
+type PromptTemplates struct {
+	tmpl *template.Template
+}
+
+// LoadPromptTemplates parses the templates overriding the built-in ones, empty paths are skipped.
+// Overriding templates can use the "context" and "diff" templates of the built-in ones.
+func LoadPromptTemplates(subjectPath, bodyPath string) (*PromptTemplates, error) {
+	tmpl, err := template.New("").Funcs(PromptFuncs).ParseFS(defaultTemplates, "templates/*.tmpl")
+	if err != nil {
+		return nil, err
+	}
+
+	for name, path := range map[string]string{SubjectTemplateName: subjectPath, BodyTemplateName: bodyPath} {
+		if path == "" {
+			continue
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := tmpl.New(name).Parse(string(content)); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+	}
+
+	return &PromptTemplates{tmpl: tmpl}, nil
+}
+
+// Instruction renders the instruction for a subject, or for a full message with a body.
+func (t *PromptTemplates) Instruction(data PromptData, body bool) (string, error) {
+	name := SubjectTemplateName
+	if body {
+		name = BodyTemplateName
+	}
+
+	var buf bytes.Buffer
+	if err := t.tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(buf.String(), "\n") + "\n", nil
+}
+
+// System renders the system prompt, the "system" template.
+func (t *PromptTemplates) System(data PromptData) (string, error) {
+	var buf bytes.Buffer
+	if err := t.tmpl.ExecuteTemplate(&buf, SystemTemplateName, data); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// Prompt renders the instruction in the prompt format of the model. Past commits of the style
+// are given as previous turns of the conversation, so the model follows them. Special tokens
+// in the text of the repository are escaped, see PromptFormat.TokenEscaper.
+func (t *PromptTemplates) Prompt(p PromptFormat, data PromptData, body bool) (string, error) {
+	data = data.escaped(p.TokenEscaper())
+	system, err := t.System(data)
+	if err != nil {
+		return "", err
+	}
+	instruction, err := t.Instruction(data, body)
+	if err != nil {
+		return "", err
+	}
+
+	return p.Conversation(system, styleExamples(p, data), instruction)
+}
+
+func styleExamples(p PromptFormat, data PromptData) []TextMessage {
+	if data.History == nil {
+		return nil
+	}
+
+	var msgs []TextMessage
+	for _, example := range data.History.Examples {
+		// Examples mustn't contradict the format the message is linted against.
+		if _, err := ParseConventionalSubject(example.Subject); data.Conventional != nil && err != nil {
+			continue
+		}
+		msgs = append(msgs, p.UserMessage(example.Changes()), p.CharMessage(example.Subject))
+	}
+
+	return msgs
+}
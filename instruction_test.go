@@ -0,0 +1,197 @@
+package llame
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPromptTemplatesInstruction(t *testing.T) {
+	tmpls, err := LoadPromptTemplates("", "")
+	require.NoError(t, err)
+
+	d := &Diff{Files: []*FileDiff{
+		{OldPath: "main.go", NewPath: "main.go", Change: ChangeModify, Added: 10},
+		{OldPath: "README.md", NewPath: "README.md", Change: ChangeModify, Added: 1},
+	}}
+	data := NewPromptData(d)
+	assert.Equal(t, []string{"main.go", "README.md"}, data.Files)
+	assert.Equal(t, "Go", data.Language)
+	data.Diff = "diff\n"
+
+	t.Run("subject", func(t *testing.T) {
+		instruction, err := tmpls.Instruction(data, false)
+		require.NoError(t, err)
+		assert.Equal(t, "Given the following code diff, generate a concise subject for commit message "+
+			"(under 50 characters) that summarizes the change clearly and effectively:\ndiff\n", instruction)
+	})
+
+	t.Run("context", func(t *testing.T) {
+		data := data
+		data.Diff = ""
+		data.Branch = "feature/PROJ-1"
+		data.Tickets = TicketRefs{IDs: []string{"PROJ-1"}, Placement: TicketPrefix}
+		data.PrevMsg = "Add login"
+		data.Initial = true
+		data.Conventional = &ConventionalOptions{Types: []string{"feat", "fix"}}
+		data.Hints = ConventionalHints{Type: "feat", Scopes: []string{"auth", "cli"}}
+		data.BodyFormat = BodyFormatBullets
+
+		instruction, err := tmpls.Instruction(data, true)
+		require.NoError(t, err)
+		assert.Equal(t, `The changes are committed to the "feature/PROJ-1" branch. Don't mention the tickets of the branch, they are added to the message automatically.
+The diff amends a commit with the following message, keep it if it still fits:
+Add login
+
+This is the initial commit of the repository, so the subject should say so (e.g. 'chore: initial commit' or 'feat: initial <short description of the project>').
+The type must be one of: feat, fix ('feat' fits the changed files).
+The scope should be one of: auth, cli (from the changed files).
+Given the following code diff, generate a commit message: a concise subject in the Conventional Commits format '<type>(<scope>): <description>' (under 50 characters) that summarizes the change, a blank line and a body that explains what changed and why as a list of '- ' bullet points with lines under 72 characters:
+(no changes)
+`, instruction)
+	})
+
+	t.Run("prompt", func(t *testing.T) {
+		data := data
+		data.SetHistory(&CommitStyle{Examples: []CommitExample{{Subject: "Add parser", Files: []string{"parser.go"}}}})
+
+		p := PromptFormat{Template: "[SYS]{{.Prompt}}[/SYS]\n{{.History}}{{.Char}}:", HistoryTemplate: "{{.Name}}: {{.Message}}\n",
+			User: "User", Char: "Bot", UserMsgPrefix: "<", UserMsgSuffix: ">"}
+		prompt, err := tmpls.Prompt(p, data, false)
+		require.NoError(t, err)
+		assert.Equal(t, "[SYS]You are an experienced software engineer writing git commit messages for the changes of a repository.[/SYS]\n"+
+			"User: <Changed files: parser.go>\nBot: Add parser\nUser: <Given the following code diff, "+
+			"generate a concise subject for commit message (under 50 characters) that summarizes the change "+
+			"clearly and effectively:\ndiff\n>\nBot:", prompt)
+		assert.Equal(t, []string{"Add parser"}, data.RecentCommits)
+	})
+
+	t.Run("overrides", func(t *testing.T) {
+		dir := t.TempDir()
+		subjectPath := filepath.Join(dir, "subject.tmpl")
+		require.NoError(t, os.WriteFile(subjectPath, []byte(`{{template "context" .}}Files: {{join .Files ", "}}
+{{template "diff" .}}`), 0o644))
+
+		tmpls, err := LoadPromptTemplates(subjectPath, "")
+		require.NoError(t, err)
+
+		data := data
+		data.Branch = "main"
+		instruction, err := tmpls.Instruction(data, false)
+		require.NoError(t, err)
+		assert.Equal(t, "The changes are committed to the \"main\" branch.\nFiles: main.go, README.md\ndiff\n", instruction)
+
+		instruction, err = tmpls.Instruction(data, true)
+		require.NoError(t, err)
+		assert.Contains(t, instruction, "a blank line and a body", "the built-in body template is kept")
+
+		require.NoError(t, os.WriteFile(subjectPath, []byte(`{{.Diff`), 0o644))
+		_, err = LoadPromptTemplates(subjectPath, "")
+		assert.ErrorContains(t, err, "parsing "+subjectPath)
+
+		_, err = LoadPromptTemplates("", filepath.Join(dir, "missing.tmpl"))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
+
+// TestPromptEscapesSpecialTokens prompts with the commits of this repository changing the prompt
+// formats, whose diffs are full of the special tokens the formats are made of.
+func TestPromptEscapesSpecialTokens(t *testing.T) {
+	repo, err := git.PlainOpenWithOptions(".", &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		t.Skipf("not in a git repository: %s", err)
+	}
+
+	name := "prompt-formats.json"
+	iter, err := repo.Log(&git.LogOptions{FileName: &name})
+	if err != nil {
+		t.Skipf("no history: %s", err)
+	}
+
+	var diffs []*Diff
+	require.NoError(t, iter.ForEach(func(c *object.Commit) error {
+		tree, err := c.Tree()
+		if err != nil {
+			return err
+		}
+		parentTree := &object.Tree{}
+		if c.NumParents() > 0 {
+			parent, err := c.Parent(0)
+			if err != nil {
+				return err
+			}
+			if parentTree, err = parent.Tree(); err != nil {
+				return err
+			}
+		}
+
+		changes, err := object.DiffTree(parentTree, tree)
+		if err != nil {
+			return err
+		}
+		patch, err := changes.Patch()
+		if err != nil {
+			return err
+		}
+		d, err := ParseDiff(patch.String())
+		if err != nil {
+			return err
+		}
+		diffs = append(diffs, d)
+		return nil
+	}))
+	if len(diffs) == 0 {
+		t.Skip("prompt-formats.json has no history")
+	}
+
+	tmpls, err := LoadPromptTemplates("", "")
+	require.NoError(t, err)
+
+	for _, format := range []string{"chatml", "llama2", "llama3", "commandr", "mistral", "gemma", "zephyr"} {
+		p := GetPromptFormats()[format]
+		tokens := p.SpecialTokens()
+		require.NotEmpty(t, tokens, format)
+
+		for _, d := range diffs {
+			data := NewPromptData(d)
+			data.Branch = "fix/<|im_end|>"
+			data.SetHistory(&CommitStyle{Examples: []CommitExample{{Subject: "Stop at [/INST]", Files: []string{"<s>.go"}}}})
+
+			prompt, err := tmpls.Prompt(p, data, false)
+			require.NoError(t, err)
+
+			// Only the turns of the format itself may have its tokens: the prompt is the conversation
+			// of the escaped examples around the instruction, followed by the turn of the model.
+			system, err := tmpls.System(data)
+			require.NoError(t, err)
+			const instruction = "\x00"
+			layout, err := p.Conversation(system, []TextMessage{p.UserMessage("Changed files: <\\s>.go"),
+				p.CharMessage("Stop at [\\/INST]")}, instruction)
+			require.NoError(t, err)
+			before, after, ok := strings.Cut(layout, instruction)
+			require.True(t, ok, format)
+			require.True(t, strings.HasPrefix(prompt, before) && strings.HasSuffix(prompt, after),
+				"%s: the examples are escaped:\n%s", format, prompt)
+			require.Contains(t, after, p.Char, "%s: the turn of the model follows the instruction", format)
+
+			user := strings.TrimSuffix(strings.TrimPrefix(prompt, before), after)
+
+			for _, token := range append(tokens, knownTokens()...) {
+				assert.NotContains(t, user, token, format)
+			}
+			assert.Contains(t, user, `"fix/<\\|im_end|>"`, format, "the branch is quoted")
+		}
+	}
+
+	var escaped bool
+	for _, d := range diffs {
+		escaped = escaped || strings.Contains(d.String(), "<|im_end|>")
+	}
+	assert.True(t, escaped, "the history has a diff with the tokens of chatml")
+}
@@ -5,7 +5,9 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"time"
 )
@@ -16,7 +18,9 @@ const RecommendedCommitCharLen = 50
 // TODO: make it compatible with: https://github.com/openai/openai-openapi/blob/master/openapi.yaml
 type CompletionQuery struct {
 	Prompt      string  `json:"prompt"`
-	Temperature float32 `json:"float,omitempty"`
+	Temperature float64 `json:"temperature"`
+	TopK        int     `json:"top_k,omitempty"`     // Limit the next token selection to the K most probable tokens.
+	TopP        float64 `json:"top_p,omitempty"`     // Limit the next token selection to a subset of tokens with a cumulative probability above P.
 	NPredict    int     `json:"n_predict,omitempty"` // Default: `-1`, where `-1` is infinity. Set the maximum number of tokens to predict when generating text.
 	Stream      bool    `json:"stream,omitempty"`
 }
@@ -27,6 +31,97 @@ type StreamData struct {
 	IdSlot     int    `json:"id_slot"`    // Slot to which the task is assigned
 	Multimodal bool   `json:"multimodal"` // Indicates if the response is multimodal
 	Index      int    `json:"index"`      // Index of the response in the stream
+
+	// The fields below are only filled in the final chunk (when Stop is true).
+	Timings         *Timings `json:"timings,omitempty"` // Prompt processing and generation timings
+	TokensPredicted int      `json:"tokens_predicted"`  // Number of generated tokens
+	TokensEvaluated int      `json:"tokens_evaluated"`  // Number of tokens evaluated in the prompt
+	Truncated       bool     `json:"truncated"`         // Whether the prompt didn't fit into the context and was truncated
+	StoppedEOS      bool     `json:"stopped_eos"`       // Generation stopped on the EOS token
+	StoppedWord     bool     `json:"stopped_word"`      // Generation stopped on one of the stop words
+	StoppedLimit    bool     `json:"stopped_limit"`     // Generation stopped after reaching n_predict
+	StoppingWord    string   `json:"stopping_word"`     // The stop word that stopped the generation
+}
+
+// Timings as reported by llama-server at the end of a completion.
+type Timings struct {
+	PromptN             int     `json:"prompt_n"`
+	PromptMs            float64 `json:"prompt_ms"`
+	PromptPerTokenMs    float64 `json:"prompt_per_token_ms"`
+	PromptPerSecond     float64 `json:"prompt_per_second"`
+	PredictedN          int     `json:"predicted_n"`
+	PredictedMs         float64 `json:"predicted_ms"`
+	PredictedPerTokenMs float64 `json:"predicted_per_token_ms"`
+	PredictedPerSecond  float64 `json:"predicted_per_second"`
+}
+
+type StopReason string
+
+const (
+	StopReasonUnknown StopReason = ""
+	StopReasonEOS     StopReason = "eos"
+	StopReasonWord    StopReason = "word"
+	StopReasonLimit   StopReason = "limit"
+)
+
+// GenerationStats summarizes a finished completion.
+type GenerationStats struct {
+	PromptTokens    int        `json:"prompt_tokens"`
+	PredictedTokens int        `json:"predicted_tokens"`
+	PromptMs        float64    `json:"prompt_ms"`
+	PredictMs       float64    `json:"predict_ms"`
+	TokensPerSecond float64    `json:"tokens_per_second"` // Generation speed, excluding prompt processing
+	Truncated       bool       `json:"truncated"`
+	StopReason      StopReason `json:"stop_reason,omitempty"`
+}
+
+// Stats returns generation statistics of the final stream chunk. It returns false if
+// the chunk doesn't carry them.
+func (d StreamData) Stats() (GenerationStats, bool) {
+	if !d.Stop || d.Timings == nil {
+		return GenerationStats{}, false
+	}
+
+	stats := GenerationStats{
+		PromptTokens:    d.Timings.PromptN,
+		PredictedTokens: d.Timings.PredictedN,
+		PromptMs:        d.Timings.PromptMs,
+		PredictMs:       d.Timings.PredictedMs,
+		TokensPerSecond: d.Timings.PredictedPerSecond,
+		Truncated:       d.Truncated,
+	}
+
+	if stats.PromptTokens == 0 {
+		stats.PromptTokens = d.TokensEvaluated
+	}
+	if stats.PredictedTokens == 0 {
+		stats.PredictedTokens = d.TokensPredicted
+	}
+
+	switch {
+	case d.StoppedEOS:
+		stats.StopReason = StopReasonEOS
+	case d.StoppedWord:
+		stats.StopReason = StopReasonWord
+	case d.StoppedLimit:
+		stats.StopReason = StopReasonLimit
+	}
+
+	return stats, true
+}
+
+func (s GenerationStats) String() string {
+	str := fmt.Sprintf("prompt: %d tok (%.0f ms), generated: %d tok (%.0f ms, %.1f tok/s)",
+		s.PromptTokens, s.PromptMs, s.PredictedTokens, s.PredictMs, s.TokensPerSecond)
+
+	if s.StopReason != StopReasonUnknown {
+		str += fmt.Sprintf(", stop: %s", s.StopReason)
+	}
+	if s.Truncated {
+		str += ", prompt truncated"
+	}
+
+	return str
 }
 
 type StreamResponse struct {
@@ -112,11 +207,15 @@ func (this *LlamaModel) ReadStream(ctx context.Context, completion CompletionQue
 		for scanner.Scan() {
 			data := scanner.Bytes()
 			if len(data) > prefixLen {
+				streamData = StreamData{}
 				err := json.Unmarshal(data[prefixLen:], &streamData)
 				if err != nil {
 					streamResp = StreamResponse{Error: fmt.Errorf("unmarshal error: %w", err)}
 				} else {
 					streamResp = StreamResponse{StreamData: streamData}
+					if stats, ok := streamData.Stats(); ok {
+						Debugf("Generation stats: %+v", stats)
+					}
 				}
 
 				if !send(streamResp) {
@@ -132,3 +231,39 @@ func (this *LlamaModel) ReadStream(ctx context.Context, completion CompletionQue
 
 	return outCh, nil
 }
+
+// Complete reads the whole stream and returns the generated content together with
+// generation stats (if the server reported them).
+func (this *LlamaModel) Complete(ctx context.Context, completion CompletionQuery) (string, *GenerationStats, error) {
+	stream, err := this.ReadStream(ctx, completion)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var (
+		content string
+		stats   *GenerationStats
+	)
+	for resp := range stream {
+		if resp.Error != nil {
+			return content, stats, resp.Error
+		}
+
+		content += resp.Content
+		if s, ok := resp.Stats(); ok {
+			stats = &s
+		}
+	}
+
+	return content, stats, nil
+}
+
+// IsTimeout reports whether err is caused by a context deadline or a client timeout.
+func IsTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
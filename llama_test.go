@@ -3,6 +3,8 @@ package llame_test
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"sync"
 	"testing"
@@ -98,3 +100,49 @@ func TestCommitGen(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestReadStreamStats(t *testing.T) {
+	chunks := []string{
+		`{"content":"Add","stop":false,"id_slot":0,"multimodal":false,"index":0}`,
+		`{"content":" stats","stop":false,"id_slot":0,"multimodal":false,"index":0}`,
+		`{"content":"","stop":true,"id_slot":0,"index":0,"tokens_predicted":2,"tokens_evaluated":120,` +
+			`"truncated":false,"stopped_eos":true,"stopped_word":false,"stopped_limit":false,"stopping_word":"",` +
+			`"timings":{"prompt_n":120,"prompt_ms":250.5,"prompt_per_token_ms":2.08,"prompt_per_second":479.0,` +
+			`"predicted_n":2,"predicted_ms":40.0,"predicted_per_token_ms":20.0,"predicted_per_second":50.0}}`,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range chunks {
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+		}
+	}))
+	defer server.Close()
+
+	llama := llame.NewLlamaCppModel(server.URL, 5*time.Second)
+	stream, err := llama.ReadStream(context.Background(), llame.CompletionQuery{Prompt: "diff"})
+	require.NoError(t, err)
+
+	var (
+		content string
+		stats   []llame.GenerationStats
+	)
+	for resp := range stream {
+		require.NoError(t, resp.Error)
+		content += resp.Content
+		if s, ok := resp.Stats(); ok {
+			stats = append(stats, s)
+		}
+	}
+
+	require.Equal(t, "Add stats", content)
+	require.Len(t, stats, 1)
+	require.Equal(t, llame.GenerationStats{
+		PromptTokens:    120,
+		PredictedTokens: 2,
+		PromptMs:        250.5,
+		PredictMs:       40,
+		TokensPerSecond: 50,
+		StopReason:      llame.StopReasonEOS,
+	}, stats[0])
+}
@@ -57,6 +57,11 @@ func Printf(msg string, args ...any) {
 }
 
 func Fatalf(msg string, args ...any) {
+	Exitf(1, msg, args...)
+}
+
+// Exitf prints the message to stderr and exits with the given code.
+func Exitf(code int, msg string, args ...any) {
 	if len(args) > 0 {
 		fmt.Fprintf(os.Stderr, msg, args...)
 	} else {
@@ -65,7 +70,7 @@ func Fatalf(msg string, args ...any) {
 
 	fmt.Fprint(os.Stderr, "\n")
 
-	os.Exit(1)
+	os.Exit(code)
 }
 
 var (
@@ -0,0 +1,51 @@
+// Code generated by scripts/genformats; DO NOT EDIT.
+
+package llame
+
+var modelToPromptFormat = map[string]string{
+	"Alpaca":            "alpaca",
+	"ChatML":            "chatml",
+	"Command R/+":       "commandr",
+	"Llama 2":           "llama2",
+	"Llama 3":           "llama3",
+	"Phi-3":             "phi3",
+	"OpenChat/Starling": "openchat",
+	"Vicuna":            "vicuna",
+	// More Prompt-Styles
+	"Airoboros L2":           "vicuna",
+	"BakLLaVA-1":             "vicuna",
+	"Code Cherry Pop":        "alpaca",
+	"Deepseek Coder":         "deepseekCoder",
+	"Dolphin Mistral":        "chatml",
+	"evolvedSeeker 1.3B":     "chatml",
+	"Goliath 120B":           "vicuna",
+	"Jordan":                 "vicuna",
+	"LLaVA":                  "vicuna",
+	"Leo Hessianai":          "chatml",
+	"Leo Mistral":            "vicuna",
+	"Marx":                   "vicuna",
+	"Med42":                  "med42",
+	"MetaMath":               "alpaca",
+	"Mistral Instruct":       "llama2",
+	"Mistral 7B OpenOrca":    "chatml",
+	"MythoMax":               "alpaca",
+	"Neural Chat":            "neuralchat",
+	"Nous Capybara":          "vicuna",
+	"Nous Hermes":            "nousHermes",
+	"OpenChat Math":          "openchatMath",
+	"OpenHermes 2.5-Mistral": "chatml",
+	"Orca Mini v3":           "alpaca",
+	"Orion":                  "orion",
+	"Samantha":               "vicuna",
+	"Samantha Mistral":       "chatml",
+	"SauerkrautLM":           "sauerkraut",
+	"Scarlett":               "vicuna",
+	"Starling Coding":        "starlingCode",
+	"Sydney":                 "alpaca",
+	"Synthia":                "vicuna",
+	"Tess":                   "vicuna",
+	"Yi-6/9/34B-Chat":        "yi34b",
+	"Zephyr":                 "zephyr",
+	// Added by llame
+	"Gemma": "gemma",
+}
@@ -2,12 +2,24 @@ package llame
 
 import (
 	"bytes"
+	"cmp"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
 	"text/template"
+	"unicode/utf8"
 )
 
+//go:generate go run ./scripts/genformats
+
 //go:embed prompt-formats.json
 var promptFormatsContent []byte
 
@@ -28,28 +40,343 @@ func init() {
 	}
 }
 
+// GetPromptFormats returns the built-in prompt formats.
 func GetPromptFormats() PromptFormats {
-	// TODO: deep copy it
-	return promptFormats
+	return maps.Clone(promptFormats)
+}
+
+// PromptFormatsDirName is the directory of the extra prompt formats next to the user config file.
+const PromptFormatsDirName = "prompt-formats"
+
+// LoadPromptFormats merges the formats of the *.json files in the directories over the built-in
+// ones, where every next directory takes precedence. The files are keyed by format name like
+// prompt-formats.json, and a format that is already known keeps the fields missing from the file,
+// e.g. only the stops of a built-in format can be changed. Missing directories are skipped.
+func LoadPromptFormats(dirs ...string) (PromptFormats, error) {
+	formats := GetPromptFormats()
+	for _, dir := range dirs {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, path := range paths {
+			if _, err := formats.MergeFile(path); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return formats, nil
+}
+
+// MergeFile merges the formats of the file, see LoadPromptFormats, and returns their names.
+func (f PromptFormats) MergeFile(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file map[string]json.RawMessage
+	if err := strictUnmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	names := slices.Sorted(maps.Keys(file))
+	for _, name := range names {
+		p := f[name]
+		if err := strictUnmarshal(file[name], &p); err != nil {
+			return nil, fmt.Errorf("%s: prompt format %q: %w", path, name, err)
+		}
+		if err := p.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: prompt format %q: %w", path, name, err)
+		}
+
+		Debugf("Loaded prompt format %q from %s", name, path)
+		f[name] = p
+	}
+
+	return names, nil
+}
+
+func strictUnmarshal(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	return dec.Decode(v)
 }
 
 type PromptFormats map[string]PromptFormat
 
+// PromptFormat renders a conversation in the format a model is trained on. Templates can
+// use the functions of PromptFuncs.
 type PromptFormat struct {
 	Template        string `json:"template"`
 	HistoryTemplate string `json:"historyTemplate"`
-	Char            string `json:"char"`
-	CharMsgPrefix   string `json:"charMsgPrefix"`
-	CharMsgSuffix   string `json:"charMsgSuffix"`
-	User            string `json:"user"`
-	UserMsgPrefix   string `json:"userMsgPrefix"`
-	UserMsgSuffix   string `json:"userMsgSuffix"`
-	Stops           string `json:"stops"`
+	// Used instead of HistoryTemplate for the messages of the role, if set.
+	UserTemplate  string `json:"userTemplate,omitempty"`
+	CharTemplate  string `json:"charTemplate,omitempty"`
+	Char          string `json:"char"`
+	CharMsgPrefix string `json:"charMsgPrefix"`
+	CharMsgSuffix string `json:"charMsgSuffix"`
+	User          string `json:"user"`
+	UserMsgPrefix string `json:"userMsgPrefix"`
+	UserMsgSuffix string `json:"userMsgSuffix"`
+	Stops         string `json:"stops"`
 }
 
 type TextMessage struct {
 	Name    string // User or Assistant
 	Message string
+	Role    MessageRole
+}
+
+// MessageRole tells which template of the history a message is rendered with.
+type MessageRole string
+
+const (
+	RoleUser MessageRole = "user"
+	RoleChar MessageRole = "char"
+)
+
+// PromptFuncs are the functions available in the templates of prompt formats and instructions.
+var PromptFuncs = template.FuncMap{
+	"trim":                strings.TrimSpace,
+	"indent":              indent,
+	"default":             defaultString,
+	"truncate":            truncate,
+	"join":                strings.Join,
+	"escapeSpecialTokens": EscapeSpecialTokens,
+	"hasSystem":           hasSystem,
+}
+
+// indent prefixes the non-empty lines with n spaces, e.g. {{.Message | indent 2}}.
+func indent(n int, s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = strings.Repeat(" ", n) + line
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// defaultString returns def if s is blank, e.g. {{.Prompt | default "You are a helpful assistant."}}.
+func defaultString(def, s string) string {
+	if strings.TrimSpace(s) == "" {
+		return def
+	}
+
+	return s
+}
+
+// truncate cuts s to n characters, e.g. {{.Diff | truncate 4000}}.
+func truncate(n int, s string) string {
+	if n < 0 || utf8.RuneCountInString(s) <= n {
+		return s
+	}
+
+	return string([]rune(s)[:n])
+}
+
+// hasSystem tells whether there is a system prompt, e.g. {{if hasSystem .Prompt}}...{{end}}.
+func hasSystem(prompt string) bool {
+	return strings.TrimSpace(prompt) != ""
+}
+
+// specialTokenRe matches the families of special tokens of the prompt formats, e.g. <|im_start|>,
+// </s>, [INST], <<SYS>> or <start_of_turn>.
+var specialTokenRe = regexp.MustCompile(`<\|[\w.-]*\|>|</?s>|\[/?INST\]|<</?SYS>>|<(?:start|end)_of_turn>|<[A-Z_]+_TOKEN>`)
+
+// tokenLikeRe matches the text of prompt formats that looks like a control token.
+var tokenLikeRe = regexp.MustCompile(`<\|[\w.-]+\|>|<</?\w+>>|</?[\w-]+>|\[/?[A-Z_]+\]`)
+
+// SpecialTokens returns the control tokens of the format, e.g. <|im_start|> and <|im_end|>.
+func (p PromptFormat) SpecialTokens() []string {
+	prompt, err := p.Conversation(lintSystem, lintExamples(p), lintInstruction)
+	if err != nil {
+		return nil
+	}
+
+	tokens := tokenLikeRe.FindAllString(prompt, -1)
+	slices.Sort(tokens)
+	return slices.Compact(tokens)
+}
+
+// knownTokens are the special tokens of the built-in formats. They're found in the text of
+// the formats, not in rendered ones, since rendering depends on PromptFuncs.
+var knownTokens = sync.OnceValue(func() []string {
+	var tokens []string
+	for _, p := range promptFormats {
+		tokens = append(tokens, tokenLikeRe.FindAllString(p.text(), -1)...)
+	}
+
+	slices.Sort(tokens)
+	return slices.Compact(tokens)
+})
+
+// knownTokensRe matches the families of special tokens and the tokens of the built-in formats.
+var knownTokensRe = sync.OnceValue(func() *regexp.Regexp {
+	return tokensRe(knownTokens())
+})
+
+func tokensRe(tokens []string) *regexp.Regexp {
+	// Longer tokens take precedence over their prefixes, e.g. <|im_start|> over <|im|>.
+	tokens = slices.Clone(tokens)
+	slices.SortFunc(tokens, func(a, b string) int {
+		return cmp.Or(cmp.Compare(len(b), len(a)), strings.Compare(a, b))
+	})
+
+	alts := []string{specialTokenRe.String()}
+	for _, token := range slices.Compact(tokens) {
+		alts = append(alts, regexp.QuoteMeta(token))
+	}
+
+	return regexp.MustCompile(strings.Join(alts, "|"))
+}
+
+func escapeTokens(re *regexp.Regexp, s string) string {
+	return re.ReplaceAllStringFunc(s, func(token string) string {
+		return token[:1] + `\` + token[1:]
+	})
+}
+
+// EscapeSpecialTokens breaks the special tokens of all the built-in formats in s with a backslash
+// after their first character (e.g. <\|im_start|>), so text such as a diff can't end a turn of
+// the conversation or inject one, since the model server parses special tokens in prompts.
+func EscapeSpecialTokens(s string) string {
+	return escapeTokens(knownTokensRe(), s)
+}
+
+// TokenEscaper returns a function escaping the special tokens of the format, besides the ones
+// of EscapeSpecialTokens, in the text of the user.
+func (p PromptFormat) TokenEscaper() func(string) string {
+	re := knownTokensRe()
+	if tokens := p.SpecialTokens(); len(tokens) > 0 {
+		re = tokensRe(append(tokens, knownTokens()...))
+	}
+
+	return func(s string) string {
+		return escapeTokens(re, s)
+	}
+}
+
+// Validate checks that the format renders a conversation.
+func (p PromptFormat) Validate() error {
+	if p.Template == "" {
+		return errors.New("template is empty")
+	}
+
+	_, err := p.Conversation(lintSystem, lintExamples(p), lintInstruction)
+	return err
+}
+
+// Sample conversation the formats are linted with, see Lint. It's laid out like the prompts
+// of commit messages, see PromptTemplates.Prompt.
+const (
+	lintSystem      = "SYSTEM PROMPT"
+	lintUserMsg     = "USER MESSAGE"
+	lintCharMsg     = "CHAR MESSAGE"
+	lintInstruction = "INSTRUCTION"
+)
+
+func lintExamples(p PromptFormat) []TextMessage {
+	return []TextMessage{p.UserMessage(lintUserMsg), p.CharMessage(lintCharMsg)}
+}
+
+var (
+	// Pairs of tags like <SYS>...</SYS> or [INST]...[/INST].
+	lintTagRe = regexp.MustCompile(`<(/?)(\w+)>|\[(/?)([A-Z_]+)\]`)
+	// Leftovers of the mustache templates the formats come from.
+	lintMustacheRe = regexp.MustCompile(`{{\s*[\w.]*\s*}}`)
+)
+
+// Lint renders a conversation in the format, as the prompts of commit messages are, and returns
+// the problems of its structure: the template must be defined, the system prompt and the history
+// must be rendered, followed by the assistant marker, the turns of the assistant must be closed
+// before the next instruction, tags opened by the user and char prefixes must be closed, stop
+// strings must be rendered (ending the turns) and no template syntax may be left.
+func (p PromptFormat) Lint() []string {
+	if err := p.Validate(); err != nil {
+		return []string{err.Error()}
+	}
+	if strings.TrimSpace(p.Template) == "TODO" {
+		return []string{"the template is a TODO placeholder"}
+	}
+
+	examples := lintExamples(p)
+	prompt, _ := p.Conversation(lintSystem, examples, lintInstruction)
+	history, _ := p.History(append(examples, p.UserMessage(lintInstruction)))
+
+	var issues []string
+	if !strings.Contains(prompt, lintSystem) {
+		issues = append(issues, "the system prompt isn't rendered, the template lacks {{.Prompt}}")
+	}
+
+	if !strings.Contains(history, lintUserMsg) || !strings.Contains(history, lintCharMsg) {
+		issues = append(issues, "the messages aren't rendered, the history template lacks {{.Message}}")
+	} else if userTurn, _ := p.History([]TextMessage{p.UserMessage(lintInstruction)}); strings.Contains(userTurn, lintInstruction) {
+		// The answer of the model must be followed by more than the start of the next turn of the user.
+		userStart, _, _ := strings.Cut(userTurn, lintInstruction)
+		_, afterChar, _ := strings.Cut(history, lintCharMsg)
+		if between, _, _ := strings.Cut(afterChar, lintInstruction); between == userStart {
+			issues = append(issues, "the turns of the assistant aren't closed before the next instruction, char messages lack a suffix")
+		}
+	}
+
+	switch i := strings.Index(prompt, history); {
+	case history == "" || i < 0:
+		issues = append(issues, "the history isn't rendered, the template lacks {{.History}}")
+	case p.Char == "":
+		issues = append(issues, "the assistant marker is empty, char isn't set")
+	case !strings.Contains(prompt[i+len(history):], p.Char):
+		issues = append(issues, fmt.Sprintf("the assistant marker %q doesn't follow the history", p.Char))
+	}
+
+	// Only the tags that are closed somewhere in the format are paired.
+	format := p.text()
+	counts := make(map[string][2]int) // Times a tag is opened and closed, keyed by the opening tag
+	for _, m := range lintTagRe.FindAllStringSubmatch(prompt, -1) {
+		open := "[" + m[4] + "]"
+		if m[2] != "" {
+			open = "<" + m[2] + ">"
+		}
+		if open == "<s>" {
+			continue // The BOS and EOS tokens, not a pair
+		}
+
+		c := counts[open]
+		if m[1]+m[3] == "" {
+			c[0]++
+		} else {
+			c[1]++
+		}
+		counts[open] = c
+	}
+	for _, open := range slices.Sorted(maps.Keys(counts)) {
+		end := open[:1] + "/" + open[1:]
+		if c := counts[open]; c[0] != c[1] && strings.Contains(format, end) {
+			issues = append(issues, fmt.Sprintf("user and char prefixes and suffixes don't balance: %d %s to %d %s",
+				c[0], open, c[1], end))
+		}
+	}
+
+	if p.Stops != "" && !strings.Contains(prompt, p.Stops) {
+		issues = append(issues, fmt.Sprintf("the stop string %q isn't rendered, the model doesn't learn to end its turns with it", p.Stops))
+	}
+
+	if leftover := lintMustacheRe.FindString(prompt); leftover != "" {
+		issues = append(issues, fmt.Sprintf("template syntax %q is left in the rendered prompt", leftover))
+	}
+
+	return issues
+}
+
+// text joins the templates, prefixes and suffixes of the format.
+func (p PromptFormat) text() string {
+	return strings.Join([]string{p.Template, p.HistoryTemplate, p.UserTemplate, p.CharTemplate,
+		p.CharMsgPrefix, p.CharMsgSuffix, p.UserMsgPrefix, p.UserMsgSuffix}, "\n")
 }
 
 func (p PromptFormat) UserContent(content string) string {
@@ -64,6 +391,7 @@ func (p PromptFormat) UserMessage(content string) TextMessage {
 	return TextMessage{
 		Name:    p.User,
 		Message: p.UserContent(content),
+		Role:    RoleUser,
 	}
 }
 
@@ -71,20 +399,36 @@ func (p PromptFormat) CharMessage(content string) TextMessage {
 	return TextMessage{
 		Name:    p.Char,
 		Message: p.CharContent(content),
+		Role:    RoleChar,
 	}
 }
 
+// History renders the messages, each with the template of its role or HistoryTemplate.
 func (p PromptFormat) History(textMsgs []TextMessage) (string, error) {
-	tmpl, err := template.New("history").Parse(p.HistoryTemplate)
-	if err != nil {
-		return "", fmt.Errorf("parsing history template: %w", err)
+	tmpls := make(map[MessageRole]*template.Template)
+	for role, text := range map[MessageRole]string{"": p.HistoryTemplate, RoleUser: p.UserTemplate, RoleChar: p.CharTemplate} {
+		if role != "" && text == "" {
+			continue
+		}
+
+		name := strings.TrimSpace(string(role) + " history")
+		tmpl, err := template.New(name).Funcs(PromptFuncs).Parse(text)
+		if err != nil {
+			return "", fmt.Errorf("parsing %s template: %w", name, err)
+		}
+		tmpls[role] = tmpl
 	}
 
 	buf := new(bytes.Buffer)
 	for _, textMsg := range textMsgs {
+		tmpl, ok := tmpls[textMsg.Role]
+		if !ok {
+			tmpl = tmpls[""]
+		}
+
 		err := tmpl.Execute(buf, textMsg)
 		if err != nil {
-			return "", fmt.Errorf("executing history template with %v message: %w", textMsg, err)
+			return "", fmt.Errorf("executing %s template with %v message: %w", tmpl.Name(), textMsg, err)
 		}
 	}
 
@@ -104,7 +448,7 @@ func (p PromptFormat) Prompt(system string, textMsgs ...TextMessage) (string, er
 		return "", err
 	}
 
-	tmpl, err := template.New("template").Parse(p.Template)
+	tmpl, err := template.New("template").Funcs(PromptFuncs).Parse(p.Template)
 	if err != nil {
 		return "", fmt.Errorf("parsing prompt template: %w", err)
 	}
@@ -124,6 +468,13 @@ func (p PromptFormat) Prompt(system string, textMsgs ...TextMessage) (string, er
 	return buf.String(), nil
 }
 
+// Conversation renders the prompt sent to the model: the examples are the previous turns of the
+// conversation and the instruction is the last message of the user, which the model answers.
+func (p PromptFormat) Conversation(system string, examples []TextMessage, instruction string) (string, error) {
+	msgs := append(slices.Clip(examples), p.UserMessage(instruction))
+	return p.Prompt(system, msgs...)
+}
+
 func (p PromptFormat) MustPrompt(system string, textMsgs ...TextMessage) string {
 	prompt, err := p.Prompt(system, textMsgs...)
 	if err != nil {
@@ -133,48 +484,7 @@ func (p PromptFormat) MustPrompt(system string, textMsgs ...TextMessage) string
 	return prompt
 }
 
-var modelToPromptFormat = map[string]string{
-	"Alpaca":            "alpaca",
-	"ChatML":            "chatml",
-	"Command R/+":       "commandr",
-	"Llama 2":           "llama2",
-	"Llama 3":           "llama3",
-	"Phi-3":             "phi3",
-	"OpenChat/Starling": "openchat",
-	"Vicuna":            "vicuna",
-	// More Prompt-Styles
-	"Airoboros L2":           "vicuna",
-	"BakLLaVA-1":             "vicuna",
-	"Code Cherry Pop":        "alpaca",
-	"Deepseek Coder":         "deepseekCoder",
-	"Dolphin Mistral":        "chatml",
-	"evolvedSeeker 1.3B":     "chatml",
-	"Goliath 120B":           "vicuna",
-	"Jordan":                 "vicuna",
-	"LLaVA":                  "vicuna",
-	"Leo Hessianai":          "chatml",
-	"Leo Mistral":            "vicuna",
-	"Marx":                   "vicuna",
-	"Med42":                  "med42",
-	"MetaMath":               "alpaca",
-	"Mistral Instruct":       "llama2",
-	"Mistral 7B OpenOrca":    "chatml",
-	"MythoMax":               "alpaca",
-	"Neural Chat":            "neuralchat",
-	"Nous Capybara":          "vicuna",
-	"Nous Hermes":            "nousHermes",
-	"OpenChat Math":          "openchatMath",
-	"OpenHermes 2.5-Mistral": "chatml",
-	"Orca Mini v3":           "alpaca",
-	"Orion":                  "orion",
-	"Samantha":               "vicuna",
-	"Samantha Mistral":       "chatml",
-	"SauerkrautLM":           "sauerkraut",
-	"Scarlett":               "vicuna",
-	"Starling Coding":        "starlingCode",
-	"Sydney":                 "alpaca",
-	"Synthia":                "vicuna",
-	"Tess":                   "vicuna",
-	"Yi-6/9/34B-Chat":        "yi34b",
-	"Zephyr":                 "zephyr",
+// ModelPromptFormats returns names of prompt formats keyed by the models using them.
+func ModelPromptFormats() map[string]string {
+	return maps.Clone(modelToPromptFormat)
 }
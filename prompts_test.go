@@ -1,10 +1,14 @@
 package llame
 
 import (
+	"bytes"
+	"os"
+	"path/filepath"
 	"testing"
 	"text/template"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestPromptTemplates(t *testing.T) {
@@ -17,10 +21,10 @@ func TestPromptTemplates(t *testing.T) {
 		}
 
 		for model, prompt := range promptFormats {
-			tmpl, err := template.New("template").Parse(prompt.Template)
+			tmpl, err := template.New("template").Funcs(PromptFuncs).Parse(prompt.Template)
 			assert.NoError(t, err, model)
 			assert.NotNil(t, tmpl, model)
-			histTmpl, err := template.New("history").Parse(prompt.HistoryTemplate)
+			histTmpl, err := template.New("history").Funcs(PromptFuncs).Parse(prompt.HistoryTemplate)
 			assert.NoError(t, err, model)
 			assert.NotNil(t, histTmpl, model)
 		}
@@ -38,6 +42,23 @@ func TestPromptTemplates(t *testing.T) {
 		assert.Equal(t, "<s>[INST] <<SYS>>\nThis is a conversation between a user and a friendly chatbot. The chatbot is helpful, kind, honest, good at writing, and never fails to answer any requests immediately and with precision\n<</SYS>>\n\nTest Message [/INST] Test Successfull </s>User: <s>[INST] Hello to you! [/INST]Assistant: Hello friend :)</s>Assistant", prompt)
 	})
 
+	t.Run("chatml", func(t *testing.T) {
+		p := promptFormats["chatml"]
+		prompt := p.MustPrompt("Be brief.", p.UserMessage("Hello to you!"), p.CharMessage("Hello friend :)"), p.UserMessage("How are you?"))
+		assert.Equal(t, "<|im_start|>system\nBe brief.<|im_end|>\n<|im_start|>user\nHello to you!<|im_end|>\n"+
+			"<|im_start|>assistant\nHello friend :)<|im_end|>\n<|im_start|>user\nHow are you?<|im_end|>\n<|im_start|>assistant\n", prompt)
+	})
+
+	t.Run("mistral", func(t *testing.T) {
+		p := promptFormats["mistral"]
+		msgs := []TextMessage{p.UserMessage("Hello to you!"), p.CharMessage("Hello friend :)"), p.UserMessage("How are you?")}
+
+		assert.Equal(t, "<s>[INST] Be brief.\n\nHello to you! [/INST] Hello friend :)</s>[INST] How are you? [/INST]",
+			p.MustPrompt("Be brief.", msgs...))
+		assert.Equal(t, "<s>[INST] Hello to you! [/INST] Hello friend :)</s>[INST] How are you? [/INST]",
+			p.MustPrompt("", msgs...), "without a system prompt")
+	})
+
 	t.Run("model prompts", func(t *testing.T) {
 		for model, p := range promptFormats {
 			userMsg := p.UserMessage("Hello to you!")
@@ -48,3 +69,159 @@ func TestPromptTemplates(t *testing.T) {
 		}
 	})
 }
+
+func TestLoadPromptFormats(t *testing.T) {
+	userDir, repoDir := t.TempDir(), t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(userDir, "granite.json"), []byte(`{
+		"granite": {
+			"template": "{{.History}}<start_of_turn>{{.Char}}\n",
+			"historyTemplate": "<start_of_turn>{{.Name}}\n{{.Message}}",
+			"char": "model",
+			"user": "user",
+			"userMsgSuffix": "<end_of_turn>\n"
+		},
+		"chatml": {"stops": "<|im_end|>"}
+	}`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(repoDir, "granite.json"), []byte(`{"granite": {"char": "assistant"}}`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(repoDir, "README.md"), []byte(`# Not a format`), 0o644))
+
+	formats, err := LoadPromptFormats(userDir, filepath.Join(repoDir, "missing"), repoDir)
+	require.NoError(t, err)
+
+	granite := formats["granite"]
+	assert.Equal(t, "assistant", granite.Char, "the repository overrides the user formats")
+	assert.Equal(t, "<start_of_turn>user\nhi<end_of_turn>\n<start_of_turn>assistant\n", granite.MustPrompt("", granite.UserMessage("hi")))
+
+	chatml := GetPromptFormats()["chatml"]
+	chatml.Stops = "<|im_end|>"
+	assert.Equal(t, chatml, formats["chatml"], "missing fields are kept")
+	assert.Empty(t, GetPromptFormats()["chatml"].Stops, "the built-in formats aren't changed")
+
+	for content, want := range map[string]string{
+		`{"bad": {"template": "{{.Foo}}"}}`:         `prompt format "bad": executing prompt template`,
+		`{"bad": {"historyTemplate": "{{.Name}}"}}`: `prompt format "bad": template is empty`,
+		`{"bad": {"templat": "{{.Prompt}}"}}`:       `prompt format "bad": json: unknown field "templat"`,
+		`["bad"]`:                                   "parsing " + filepath.Join(repoDir, "bad.json"),
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(repoDir, "bad.json"), []byte(content), 0o644))
+		_, err := LoadPromptFormats(repoDir)
+		assert.ErrorContains(t, err, want, content)
+	}
+}
+
+func TestPromptFormatLint(t *testing.T) {
+	noSystem := "the system prompt isn't rendered, the template lacks {{.Prompt}}"
+	notClosed := "the turns of the assistant aren't closed before the next instruction, char messages lack a suffix"
+	for name, p := range GetPromptFormats() {
+		assert.Empty(t, p.Lint(), name)
+	}
+
+	valid := PromptFormat{
+		Template:        "[SYS]{{.Prompt}}[/SYS]{{.History}}{{.Char}}:",
+		HistoryTemplate: "{{.Name}}: {{.Message}}\n",
+		Char:            "Bot",
+		User:            "User",
+		UserMsgPrefix:   "[INST] ",
+		UserMsgSuffix:   " [/INST]",
+		CharMsgSuffix:   "<end>",
+		Stops:           "<end>",
+	}
+	assert.Empty(t, valid.Lint())
+
+	for _, tc := range []struct {
+		edit func(p *PromptFormat)
+		want []string
+	}{
+		{func(p *PromptFormat) { p.Template = "" }, []string{"template is empty"}},
+		{func(p *PromptFormat) { p.Template = "TODO\n" }, []string{"the template is a TODO placeholder"}},
+		{func(p *PromptFormat) { p.Template = "{{.History}}{{.Char}}" }, []string{noSystem}},
+		{func(p *PromptFormat) { p.HistoryTemplate = "{{.Name}}:\n" }, []string{"the messages aren't rendered, the history template lacks {{.Message}}",
+			`the stop string "<end>" isn't rendered, the model doesn't learn to end its turns with it`}},
+		{func(p *PromptFormat) { p.Template = "{{.Prompt}}{{.Char}}" }, []string{"the history isn't rendered, the template lacks {{.History}}",
+			`the stop string "<end>" isn't rendered, the model doesn't learn to end its turns with it`}},
+		{func(p *PromptFormat) { p.Template = "{{.Prompt}}{{.Char}}:{{.History}}" }, []string{`the assistant marker "Bot" doesn't follow the history`}},
+		{func(p *PromptFormat) { p.Char = "" }, []string{"the assistant marker is empty, char isn't set"}},
+		{func(p *PromptFormat) { p.UserMsgPrefix = "[INST] [INST] " }, []string{"user and char prefixes and suffixes don't balance: 4 [INST] to 2 [/INST]"}},
+		{func(p *PromptFormat) { p.UserMsgSuffix = "" }, nil},
+		{func(p *PromptFormat) { p.CharMsgSuffix = "</end>" }, []string{"user and char prefixes and suffixes don't balance: 0 <end> to 1 </end>",
+			`the stop string "<end>" isn't rendered, the model doesn't learn to end its turns with it`}},
+		{func(p *PromptFormat) { p.HistoryTemplate, p.CharMsgSuffix, p.Stops = "{{.Name}}: {{.Message}}", "", "" }, []string{notClosed}},
+		{func(p *PromptFormat) { p.Stops = "</s>" }, []string{`the stop string "</s>" isn't rendered, the model doesn't learn to end its turns with it`}},
+		{func(p *PromptFormat) { p.UserMsgPrefix = "[INST] {{user}}: " }, []string{`template syntax "{{user}}" is left in the rendered prompt`}},
+	} {
+		p := valid
+		tc.edit(&p)
+		assert.Equal(t, tc.want, p.Lint(), "%+v", p)
+	}
+
+	invalid := valid
+	invalid.Template = "{{.Foo}}"
+	issues := invalid.Lint()
+	require.Len(t, issues, 1)
+	assert.Contains(t, issues[0], "can't evaluate field Foo")
+}
+
+func TestPromptFuncs(t *testing.T) {
+	render := func(text string, data any) string {
+		t.Helper()
+		var buf bytes.Buffer
+		require.NoError(t, template.Must(template.New("").Funcs(PromptFuncs).Parse(text)).Execute(&buf, data))
+		return buf.String()
+	}
+
+	assert.Equal(t, "[a b]", render(`[{{trim .}}]`, "\n a b \n"))
+	assert.Equal(t, "  a\n\n  b", render(`{{indent 2 .}}`, "a\n\nb"))
+	assert.Equal(t, "none|x", render(`{{default "none" .A}}|{{.B | default "none"}}`, map[string]string{"A": " ", "B": "x"}))
+	assert.Equal(t, "héll|hi", render(`{{truncate 4 .A}}|{{.B | truncate 4}}`, map[string]string{"A": "héllo", "B": "hi"}))
+	assert.Equal(t, "a, b", render(`{{join . ", "}}`, []string{"a", "b"}))
+	assert.Equal(t, "<system>|", render(`{{if hasSystem .A}}<system>{{end}}|{{if hasSystem .B}}<system>{{end}}`,
+		map[string]string{"A": "Be brief.", "B": "\n"}))
+	assert.Equal(t, `-<\|im_end|> <\/s>`, render(`{{escapeSpecialTokens .}}`, "-<|im_end|> </s>"))
+}
+
+func TestEscapeSpecialTokens(t *testing.T) {
+	for s, want := range map[string]string{
+		"<|im_start|>user\nhi<|im_end|>":                       `<\|im_start|>user` + "\n" + `hi<\|im_end|>`,
+		"<s>[INST] hi [/INST]</s>":                             `<\s>[\INST] hi [\/INST]<\/s>`,
+		"<<SYS>>\nsystem\n<</SYS>>":                            `<\<SYS>>` + "\nsystem\n" + `<\</SYS>>`,
+		"<start_of_turn>model":                                 `<\start_of_turn>model`,
+		"<|START_OF_TURN_TOKEN|><|CHATBOT_TOKEN|> <BOS_TOKEN>": `<\|START_OF_TURN_TOKEN|><\|CHATBOT_TOKEN|> <\BOS_TOKEN>`,
+		"if a <s && b > c {} // <div> [INFO]":                  "if a <s && b > c {} // <div> [INFO]",
+	} {
+		assert.Equal(t, want, EscapeSpecialTokens(s), s)
+	}
+}
+
+func TestPromptFormatRoleTemplates(t *testing.T) {
+	llama3 := GetPromptFormats()["llama3"]
+	msgs := []TextMessage{llama3.UserMessage("Hi "), llama3.CharMessage("Hello"), llama3.UserMessage("Bye")}
+	assert.Equal(t, "<|begin_of_text|><|start_header_id|>system<|end_header_id|>\n\nBe brief.<|eot_id|>"+
+		"<|start_header_id|>user<|end_header_id|>\n\nHi<|eot_id|><|start_header_id|>assistant<|end_header_id|>\n\nHello<|eot_id|>"+
+		"<|start_header_id|>user<|end_header_id|>\n\nBye<|eot_id|><|start_header_id|>assistant<|end_header_id|>\n\n",
+		llama3.MustPrompt("Be brief.\n", msgs...))
+	assert.Equal(t, "<|begin_of_text|><|start_header_id|>assistant<|end_header_id|>\n\n", llama3.MustPrompt(""))
+	assert.Empty(t, llama3.Lint())
+
+	gemma := GetPromptFormats()["gemma"]
+	msgs = []TextMessage{gemma.UserMessage("Hi "), gemma.CharMessage("Hello"), gemma.UserMessage("Bye")}
+	assert.Equal(t, "<bos><start_of_turn>user\nBe brief.\n\nHi<end_of_turn>\n<start_of_turn>model\nHello<end_of_turn>\n"+
+		"<start_of_turn>user\nBye<end_of_turn>\n<start_of_turn>model\n", gemma.MustPrompt("Be brief.\n", msgs...))
+	assert.Equal(t, "<bos><start_of_turn>user\nHi<end_of_turn>\n<start_of_turn>model\n", gemma.MustPrompt("", msgs[0]))
+	assert.Empty(t, gemma.Lint())
+
+	custom := PromptFormat{
+		Template:        "<bos>{{.History}}<start_of_turn>{{.Char}}\n",
+		HistoryTemplate: "<start_of_turn>{{.Name}}\n{{.Message}}<end_of_turn>\n",
+		CharTemplate:    "<start_of_turn>model\n{{.Message | truncate 5}}<end_of_turn>\n",
+		Char:            "model",
+		User:            "user",
+	}
+	assert.Equal(t, "<bos><start_of_turn>user\nHi<end_of_turn>\n<start_of_turn>model\nHello<end_of_turn>\n<start_of_turn>model\n",
+		custom.MustPrompt("", custom.UserMessage("Hi"), custom.CharMessage("Hello world")), "the history template is the default one")
+
+	custom.UserTemplate = "{{.Foo}}"
+	_, err := custom.Prompt("", custom.UserMessage("Hi"))
+	assert.ErrorContains(t, err, "executing user history template")
+	custom.CharTemplate = "{{"
+	assert.ErrorContains(t, custom.Validate(), "parsing char history template")
+}
@@ -0,0 +1,273 @@
+package llame
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// RedactMode is what is done when secrets are found in the staged changes.
+type RedactMode string
+
+const (
+	RedactModeRedact RedactMode = "redact" // Replace the secrets with placeholders
+	RedactModeRefuse RedactMode = "refuse" // Don't generate a message
+)
+
+var SecretsFoundErr = errors.New("secrets found in the staged changes")
+
+// RedactOptions configures finding secrets in diffs, besides the built-in rules.
+type RedactOptions struct {
+	Patterns []string // Regexps of secrets, the first group is the secret if there are groups
+	Entropy  bool     // Redact random looking strings, e.g. API tokens of unknown formats
+}
+
+// Redaction is a secret replaced in a diff.
+type Redaction struct {
+	Rule string // Name of the rule that found the secret, e.g. "jwt"
+	Path string
+	Line int // In the new version of the file, or in the old one for deleted lines
+}
+
+// String describes the redaction, e.g. "aws-access-key-id in .env:3".
+func (r Redaction) String() string {
+	return fmt.Sprintf("%s in %s:%d", r.Rule, r.Path, r.Line)
+}
+
+// Placeholder is what the secret is replaced with.
+func (r Redaction) Placeholder() string {
+	return "[REDACTED:" + r.Rule + "]"
+}
+
+type secretRule struct {
+	name  string
+	re    *regexp.Regexp         // The first group is the secret if there are groups
+	files func(path string) bool // The files the rule applies to, all if nil
+}
+
+var secretRules = []secretRule{
+	{"aws-access-key-id", regexp.MustCompile(`\b(?:AKIA|ASIA|ABIA|ACCA)[0-9A-Z]{16}\b`), nil},
+	{"aws-secret-access-key", regexp.MustCompile(`(?i)aws[\w.-]{0,20}(?:secret|key)[\w.-]{0,20}["']?\s*[:=]\s*["']?([A-Za-z0-9/+]{40})\b`), nil},
+	{"github-token", regexp.MustCompile(`\b(?:gh[pousr]|github_pat)_[A-Za-z0-9_]{36,}\b`), nil},
+	{"slack-token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`), nil},
+	{"jwt", regexp.MustCompile(`\beyJ[\w-]{5,}\.eyJ[\w-]{5,}\.[\w-]{10,}`), nil},
+	// Values of any length, e.g. PASSWORD=hunter2, which the entropy check doesn't catch.
+	{"secret-assignment", regexp.MustCompile(`(?i)^\s*(?:export\s+)?[\w.-]*(?:PASS|SECRET|TOKEN|KEY)[\w.-]*\s*[=:]\s*["']?([^"'\s#\[][^"'\s#]*)`), isDotenvFile},
+}
+
+var (
+	privateKeyRe   = regexp.MustCompile(`-----(?:BEGIN|END)[A-Z ]*PRIVATE KEY( BLOCK)?-----`)
+	randomStringRe = regexp.MustCompile(`[A-Za-z0-9+/_-]{20,}={0,2}`)
+	wordRe         = regexp.MustCompile(`^[a-z]{3,}$`)
+)
+
+// Strings are random looking with at least this entropy per character, in bits, which
+// is rarely reached by words, paths and identifiers, or hex hashes (at most 4 bits).
+const secretEntropyMin = 4.2
+
+// RedactDiff replaces the secrets in the changes of the diff with placeholders, see
+// Redaction.Placeholder, and returns them. Files of private keys are redacted as whole hunks,
+// see isPrivateKeyFile.
+func RedactDiff(d *Diff, opts RedactOptions) ([]Redaction, error) {
+	rules := secretRules
+	for i, pattern := range opts.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret pattern %q: %w", pattern, err)
+		}
+		rules = append(rules[:len(rules):len(rules)], secretRule{name: fmt.Sprintf("pattern-%d", i+1), re: re})
+	}
+
+	if d == nil {
+		return nil, nil
+	}
+
+	var redactions []Redaction
+	for _, f := range d.Files {
+		if f.Summarized {
+			continue
+		}
+		if isPrivateKeyFile(f) {
+			for _, h := range f.Hunks {
+				if r, ok := redactHunk(f, h, "private-key"); ok {
+					redactions = append(redactions, r)
+				}
+			}
+			continue
+		}
+
+		for _, h := range f.Hunks {
+			oldLine, newLine := h.OldStart, h.NewStart
+			for i, line := range h.Lines {
+				if line == "" || line[0] == '\\' {
+					continue
+				}
+
+				r := Redaction{Path: f.Path(), Line: newLine}
+				if line[0] == '-' {
+					r.Line = oldLine
+				}
+				switch line[0] {
+				case '+':
+					newLine++
+				case '-':
+					oldLine++
+				default:
+					oldLine++
+					newLine++
+				}
+
+				prefix, text := line[:1], line[1:]
+				for _, rule := range rules {
+					if rule.files != nil && !rule.files(f.Path()) {
+						continue
+					}
+					text = redactMatches(text, rule.re, func() string {
+						r.Rule = rule.name
+						redactions = append(redactions, r)
+						return r.Placeholder()
+					})
+				}
+
+				if opts.Entropy {
+					text = randomStringRe.ReplaceAllStringFunc(text, func(s string) string {
+						if !isRandom(s) {
+							return s
+						}
+
+						r.Rule = "high-entropy-string"
+						redactions = append(redactions, r)
+						return r.Placeholder()
+					})
+				}
+
+				h.Lines[i] = prefix + text
+			}
+		}
+	}
+
+	return redactions, nil
+}
+
+// isPrivateKeyFile tells whether the file is a private key by its name (id_rsa, key.pem, tls.key), or
+// by the markers of a key in any of its hunks: a hunk can start in the middle of the key, past its
+// BEGIN line, and the rest of the key can't be told from other text.
+func isPrivateKeyFile(f *FileDiff) bool {
+	name := path.Base(f.Path())
+	switch {
+	case strings.HasPrefix(name, "id_") && path.Ext(name) != ".pub",
+		path.Ext(name) == ".pem", path.Ext(name) == ".key":
+		return true
+	}
+
+	for _, h := range f.Hunks {
+		for _, line := range h.Lines {
+			if privateKeyRe.MatchString(line) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// isDotenvFile tells whether the file has KEY=value lines: .env files (e.g. .env.local or
+// prod.env) and ini-style configs.
+func isDotenvFile(p string) bool {
+	name := path.Base(p)
+	switch path.Ext(name) {
+	case ".env", ".ini", ".cfg", ".conf", ".properties":
+		return true
+	}
+
+	return name == ".env" || strings.HasPrefix(name, ".env.")
+}
+
+// redactHunk replaces the first line of the hunk with the placeholder of the rule and empties the
+// rest, so the hunk keeps its ranges. It's false if the hunk is already redacted.
+func redactHunk(f *FileDiff, h *Hunk, rule string) (Redaction, bool) {
+	r := Redaction{Rule: rule, Path: f.Path(), Line: h.NewStart}
+
+	first := true
+	redacted := true
+	for i, line := range h.Lines {
+		if line == "" || line[0] == '\\' {
+			continue
+		}
+
+		text := ""
+		if first {
+			if line[0] == '-' {
+				r.Line = h.OldStart
+			}
+			text, first = r.Placeholder(), false
+		}
+		redacted = redacted && line[1:] == text
+		h.Lines[i] = line[:1] + text
+	}
+
+	return r, !first && !redacted
+}
+
+// redactMatches replaces the matches of re (or their first group) with placeholders.
+func redactMatches(s string, re *regexp.Regexp, placeholder func() string) string {
+	matches := re.FindAllStringSubmatchIndex(s, -1)
+	if len(matches) == 0 {
+		return s
+	}
+
+	var sb strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		if len(m) > 2 && m[2] >= 0 {
+			start, end = m[2], m[3]
+		}
+
+		sb.WriteString(s[last:start])
+		sb.WriteString(placeholder())
+		last = end
+	}
+	sb.WriteString(s[last:])
+
+	return sb.String()
+}
+
+// isRandom tells whether s looks like a generated token: it mixes upper and lower case
+// letters with digits, has a high entropy and isn't made of words like paths and names are,
+// e.g. "feature/PROJ-1234-add-login".
+func isRandom(s string) bool {
+	words := 0
+	for _, part := range strings.FieldsFunc(s, func(r rune) bool { return r == '/' || r == '_' || r == '-' }) {
+		if wordRe.MatchString(part) {
+			words++
+		}
+	}
+	if words > 1 {
+		return false
+	}
+
+	var upper, lower, digit bool
+	counts := make(map[rune]int)
+	for _, r := range s {
+		upper = upper || unicode.IsUpper(r)
+		lower = lower || unicode.IsLower(r)
+		digit = digit || unicode.IsDigit(r)
+		counts[r]++
+	}
+	if !upper || !lower || !digit {
+		return false
+	}
+
+	var entropy float64
+	for _, n := range counts {
+		p := float64(n) / float64(len(s))
+		entropy -= p * math.Log2(p)
+	}
+
+	return entropy >= secretEntropyMin
+}
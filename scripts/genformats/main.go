@@ -0,0 +1,532 @@
+// Genformats regenerates prompt-formats.json and the table of models using the formats
+// (prompt_models.go) from the prompt formats of the llama.cpp server web UI.
+//
+// The formats are read from prompt-formats.js, where the mustache variables of the templates
+// ({{prompt}}) are converted to Go ones ({{.Prompt}}), while Go template actions, e.g.
+// {{if hasSystem .Prompt}}...{{end}}, are kept as is. The models are read from the options of
+// the prompt format select of the web UI, where the label of an optgroup becomes a comment.
+// Both are local copies in scripts/ by default, but can be given as http(s) URLs of the upstream files.
+//
+// The changes of llame are kept apart from the upstream files, in prompt-formats.llame.js: its
+// formats are merged over the upstream ones field by field (the ones missing upstream are appended),
+// and its models are added to the upstream ones.
+//
+// Usage (from the root of the repository, see go:generate in prompts.go):
+//
+//	go run ./scripts/genformats [-formats path|url] [-models path|url] [-overlay path]
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"html"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/meddion/llame"
+)
+
+func main() {
+	formatsSrc := flag.String("formats", "scripts/prompt-formats.js", "Path or URL of the prompt formats of the web UI.")
+	modelsSrc := flag.String("models", "scripts/prompt-models.html", "Path or URL of the HTML with the prompt format select of the web UI.")
+	overlaySrc := flag.String("overlay", "scripts/prompt-formats.llame.js", "Path of the changes of the formats and models, none if empty.")
+	formatsOut := flag.String("formats-out", "prompt-formats.json", "Where to write the prompt formats.")
+	modelsOut := flag.String("models-out", "prompt_models.go", "Where to write the table of models.")
+	flag.Parse()
+
+	log.SetFlags(0)
+	log.SetPrefix("genformats: ")
+
+	formats, err := readFormats(*formatsSrc, *overlaySrc)
+	if err != nil {
+		log.Fatalf("reading formats: %s", err)
+	}
+
+	models, err := readModels(*modelsSrc, *overlaySrc, formats)
+	if err != nil {
+		log.Fatalf("reading models: %s", err)
+	}
+
+	if err := writeFormats(*formatsOut, formats); err != nil {
+		log.Fatalf("writing formats: %s", err)
+	}
+	if err := writeModels(*modelsOut, models); err != nil {
+		log.Fatalf("writing models: %s", err)
+	}
+}
+
+// namedFormat keeps the order of the formats of the source.
+type namedFormat struct {
+	name   string
+	format llame.PromptFormat
+}
+
+type model struct {
+	name, format string
+	group        string // Label of the optgroup, if any
+}
+
+func read(src string) ([]byte, error) {
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		return os.ReadFile(src)
+	}
+
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(src)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", src, resp.Status)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// mustacheVarRe matches the variables of the mustache templates, not to take Go template
+// actions such as {{end}} for them.
+var mustacheVarRe = regexp.MustCompile(`{{(prompt|history|char|user|name|message)}}`)
+
+// goTemplate turns mustache variables into Go template ones.
+func goTemplate(s string) string {
+	return mustacheVarRe.ReplaceAllStringFunc(s, func(v string) string {
+		name := v[2 : len(v)-2]
+		r, size := utf8.DecodeRuneInString(name)
+		return "{{." + string(unicode.ToUpper(r)) + name[size:] + "}}"
+	})
+}
+
+// formatFields are the fields of a format in the source, with Go templates.
+type formatFields struct {
+	name   string
+	fields map[string]string
+}
+
+// readFormats reads the formats of src with the ones of the overlay merged over them.
+func readFormats(src, overlaySrc string) ([]namedFormat, error) {
+	formats, err := readFormatFields(src)
+	if err != nil {
+		return nil, err
+	}
+
+	if overlaySrc != "" {
+		overlay, err := readFormatFields(overlaySrc)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, o := range overlay {
+			i := slices.IndexFunc(formats, func(f formatFields) bool { return f.name == o.name })
+			if i < 0 {
+				formats = append(formats, o)
+				continue
+			}
+			maps.Copy(formats[i].fields, o.fields)
+		}
+	}
+
+	var named []namedFormat
+	for _, f := range formats {
+		// Fields are matched by their JSON names, so unknown ones are caught by the decoder.
+		data, err := json.Marshal(f.fields)
+		if err != nil {
+			return nil, err
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+
+		var p llame.PromptFormat
+		if err := dec.Decode(&p); err != nil {
+			return nil, fmt.Errorf("format %q: %w", f.name, err)
+		}
+		if err := p.Validate(); err != nil {
+			return nil, fmt.Errorf("format %q: %w", f.name, err)
+		}
+
+		named = append(named, namedFormat{name: f.name, format: p})
+	}
+
+	return named, nil
+}
+
+// readFormatFields reads the promptFormats object of the JS source.
+func readFormatFields(src string) ([]formatFields, error) {
+	obj, err := readObject(src, "promptFormats")
+	if err != nil {
+		return nil, err
+	}
+
+	var formats []formatFields
+	for _, entry := range obj {
+		fields, ok := entry.value.([]jsEntry)
+		if !ok {
+			return nil, fmt.Errorf("%s: format %q isn't an object", src, entry.key)
+		}
+
+		values := make(map[string]string, len(fields))
+		for _, field := range fields {
+			s, ok := field.value.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: format %q: %s isn't a string", src, entry.key, field.key)
+			}
+			values[field.key] = goTemplate(s)
+		}
+
+		formats = append(formats, formatFields{name: entry.key, fields: values})
+	}
+
+	if len(formats) == 0 {
+		return nil, fmt.Errorf("%s: no formats", src)
+	}
+
+	return formats, nil
+}
+
+// readObject reads the object literal assigned to the name in the JS source.
+func readObject(src, name string) ([]jsEntry, error) {
+	content, err := read(src)
+	if err != nil {
+		return nil, err
+	}
+
+	decl := name + " ="
+	start := bytes.Index(content, []byte(decl))
+	if start < 0 {
+		return nil, fmt.Errorf("%s: %q isn't found", src, decl)
+	}
+
+	p := &jsParser{src: string(content), pos: start + len(decl)}
+	obj, err := p.object()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", src, err)
+	}
+
+	return obj, nil
+}
+
+var (
+	optgroupRe = regexp.MustCompile(`<optgroup\s+label="([^"]*)"`)
+	optionRe   = regexp.MustCompile(`<option\s+value="([^"]*)"[^>]*>([^<]*)</option>`)
+	tagRe      = regexp.MustCompile(`<optgroup\s+label="[^"]*"|<option\s+value="[^"]*"[^>]*>[^<]*</option>|</optgroup>`)
+)
+
+// overlayGroup is the group of the models of the overlay.
+const overlayGroup = "Added by llame"
+
+// readModels reads the options of the prompt format select, the ones of unknown formats
+// (e.g. "default") are skipped, followed by the models of the overlay.
+func readModels(src, overlaySrc string, formats []namedFormat) ([]model, error) {
+	content, err := read(src)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]bool, len(formats))
+	for _, f := range formats {
+		known[f.name] = true
+	}
+
+	var (
+		models []model
+		group  string
+		seen   = make(map[string]bool)
+	)
+	for _, tag := range tagRe.FindAllString(string(content), -1) {
+		switch {
+		case tag == "</optgroup>":
+			group = ""
+		case optgroupRe.MatchString(tag):
+			group = html.UnescapeString(optgroupRe.FindStringSubmatch(tag)[1])
+		default:
+			m := optionRe.FindStringSubmatch(tag)
+			name, format := strings.TrimSpace(html.UnescapeString(m[2])), html.UnescapeString(m[1])
+			if !known[format] {
+				log.Printf("skipping model %q of unknown format %q", name, format)
+				continue
+			}
+			if seen[name] {
+				return nil, fmt.Errorf("%s: model %q is listed twice", src, name)
+			}
+			seen[name] = true
+
+			models = append(models, model{name: name, format: format, group: group})
+		}
+	}
+
+	if overlaySrc != "" {
+		obj, err := readObject(overlaySrc, "promptModels")
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range obj {
+			format, ok := entry.value.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: the format of model %q isn't a string", overlaySrc, entry.key)
+			}
+			if !known[format] {
+				return nil, fmt.Errorf("%s: model %q: unknown format %q", overlaySrc, entry.key, format)
+			}
+			if seen[entry.key] {
+				return nil, fmt.Errorf("%s: model %q is listed twice", overlaySrc, entry.key)
+			}
+			seen[entry.key] = true
+
+			models = append(models, model{name: entry.key, format: format, group: overlayGroup})
+		}
+	}
+
+	if len(models) == 0 {
+		return nil, fmt.Errorf("%s: no models", src)
+	}
+
+	return models, nil
+}
+
+func writeFormats(path string, formats []namedFormat) error {
+	var buf bytes.Buffer
+	buf.WriteString("{\n")
+	for i, f := range formats {
+		var value bytes.Buffer
+		enc := json.NewEncoder(&value)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("  ", "  ")
+		if err := enc.Encode(f.format); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(&buf, "  %s: %s", strconv.Quote(f.name), bytes.TrimSuffix(value.Bytes(), []byte("\n")))
+		if i < len(formats)-1 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("\n")
+	}
+	buf.WriteString("}\n")
+
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
+
+func writeModels(path string, models []model) error {
+	var buf bytes.Buffer
+	buf.WriteString("// Code generated by scripts/genformats; DO NOT EDIT.\n\npackage llame\n\n")
+	buf.WriteString("var modelToPromptFormat = map[string]string{\n")
+	group := ""
+	for _, m := range models {
+		if m.group != group && m.group != "" {
+			fmt.Fprintf(&buf, "// %s\n", m.group)
+		}
+		group = m.group
+		fmt.Fprintf(&buf, "%s: %s,\n", strconv.Quote(m.name), strconv.Quote(m.format))
+	}
+	buf.WriteString("}\n")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, src, 0o644)
+}
+
+// jsEntry is a property of a JS object literal, its value is a string or []jsEntry.
+type jsEntry struct {
+	key   string
+	value any
+}
+
+// jsParser parses the subset of JS used by prompt-formats.js: object literals
+// with string values, and comments.
+type jsParser struct {
+	src string
+	pos int
+}
+
+func (p *jsParser) errorf(format string, args ...any) error {
+	line := strings.Count(p.src[:p.pos], "\n") + 1
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+// skip skips whitespace and comments.
+func (p *jsParser) skip() {
+	for p.pos < len(p.src) {
+		switch rest := p.src[p.pos:]; {
+		case unicode.IsSpace(rune(rest[0])):
+			p.pos++
+		case strings.HasPrefix(rest, "//"):
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				end = len(rest)
+			}
+			p.pos += end
+		case strings.HasPrefix(rest, "/*"):
+			end := strings.Index(rest, "*/")
+			if end < 0 {
+				p.pos = len(p.src)
+				return
+			}
+			p.pos += end + 2
+		default:
+			return
+		}
+	}
+}
+
+func (p *jsParser) peek() byte {
+	p.skip()
+	if p.pos >= len(p.src) {
+		return 0
+	}
+
+	return p.src[p.pos]
+}
+
+func (p *jsParser) object() ([]jsEntry, error) {
+	if p.peek() != '{' {
+		return nil, p.errorf("expected an object")
+	}
+	p.pos++
+
+	var entries []jsEntry
+	for {
+		if p.peek() == '}' {
+			p.pos++
+			return entries, nil
+		}
+
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ':' {
+			return nil, p.errorf("expected ':' after %q", key)
+		}
+		p.pos++
+
+		var value any
+		if p.peek() == '{' {
+			value, err = p.object()
+		} else {
+			value, err = p.string()
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, jsEntry{key: key, value: value})
+
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case '}':
+		default:
+			return nil, p.errorf("expected ',' or '}' after %q", key)
+		}
+	}
+}
+
+func (p *jsParser) key() (string, error) {
+	switch c := p.peek(); {
+	case c == '"' || c == '\'':
+		return p.string()
+	case c == '_' || c == '$' || unicode.IsLetter(rune(c)):
+		start := p.pos
+		for p.pos < len(p.src) {
+			c := rune(p.src[p.pos])
+			if c != '_' && c != '$' && !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+				break
+			}
+			p.pos++
+		}
+		return p.src[start:p.pos], nil
+	default:
+		return "", p.errorf("expected a property name")
+	}
+}
+
+// string parses a quoted string or a template literal without substitutions.
+func (p *jsParser) string() (string, error) {
+	quote := p.peek()
+	if quote != '"' && quote != '\'' && quote != '`' {
+		return "", p.errorf("expected a string")
+	}
+	p.pos++
+
+	var sb strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == quote:
+			p.pos++
+			return sb.String(), nil
+		case c == '\\':
+			if err := p.escape(&sb); err != nil {
+				return "", err
+			}
+		case c == '$' && quote == '`' && strings.HasPrefix(p.src[p.pos:], "${"):
+			return "", p.errorf("substitutions in template literals aren't supported")
+		case c == '\n' && quote != '`':
+			return "", p.errorf("unterminated string")
+		default:
+			sb.WriteByte(c)
+			p.pos++
+		}
+	}
+
+	return "", p.errorf("unterminated string")
+}
+
+var jsEscapes = map[byte]string{
+	'n': "\n", 't': "\t", 'r': "\r", 'b': "\b", 'f': "\f", 'v': "\v", '0': "\x00",
+	'\\': "\\", '\'': "'", '"': "\"", '`': "`", '$': "$", '\n': "",
+}
+
+func (p *jsParser) escape(sb *strings.Builder) error {
+	if p.pos+1 >= len(p.src) {
+		return p.errorf("unterminated escape sequence")
+	}
+
+	c := p.src[p.pos+1]
+	if s, ok := jsEscapes[c]; ok {
+		sb.WriteString(s)
+		p.pos += 2
+		return nil
+	}
+
+	var digits int
+	switch c {
+	case 'x':
+		digits = 2
+	case 'u':
+		digits = 4
+	default:
+		return p.errorf("unsupported escape sequence \\%c", c)
+	}
+
+	start := p.pos + 2
+	if start+digits > len(p.src) {
+		return p.errorf("unterminated escape sequence")
+	}
+	r, err := strconv.ParseUint(p.src[start:start+digits], 16, 32)
+	if err != nil {
+		return errors.Join(p.errorf("invalid escape sequence"), err)
+	}
+	sb.WriteRune(rune(r))
+	p.pos = start + digits
+
+	return nil
+}
@@ -0,0 +1,135 @@
+// llame's changes of the upstream prompt formats in prompt-formats.js, which is kept as it is
+// upstream. genformats merges the fields of a format over the upstream ones and appends the
+// formats missing upstream, and the models are added to the ones of prompt-models.html.
+export const promptFormats = {
+  // Turns are separated by blank lines, and the turn of the assistant is started like the others.
+  "alpaca": {
+  template: `{{prompt}}\n\n{{history}}### {{char}}:\n`,
+  historyTemplate: `### {{name}}:\n{{message}}\n\n`,
+  },
+
+  // The turns of the assistant are closed and the last one is started with <|im_start|>.
+  "chatml": {
+  template: `<|im_start|>system\n{{prompt}}<|im_end|>\n{{history}}<|im_start|>{{char}}\n`,
+  charMsgSuffix: "<|im_end|>\n",
+  },
+
+  // The turns of the chatbot are closed and the last one is started like the others.
+  "commandr": {
+  template: `<BOS_TOKEN><|START_OF_TURN_TOKEN|><|SYSTEM_TOKEN|>{{prompt}}\n<|END_OF_TURN_TOKEN|>{{history}}<|START_OF_TURN_TOKEN|><|{{char}}|>`,
+  charMsgSuffix: "<|END_OF_TURN_TOKEN|>",
+  },
+
+  // The system prompt is ended with <|eot_id|> and skipped if empty, the turn of the assistant
+  // is started with its header.
+  "llama3": {
+  template: `<|begin_of_text|>{{if hasSystem .Prompt}}<|start_header_id|>system<|end_header_id|>\n\n{{trim .Prompt}}<|eot_id|>{{end}}{{history}}<|start_header_id|>{{char}}<|end_header_id|>\n\n`,
+  userTemplate: `<|start_header_id|>user<|end_header_id|>\n\n{{trim .Message}}<|eot_id|>`,
+  charTemplate: `<|start_header_id|>assistant<|end_header_id|>\n\n{{trim .Message}}<|eot_id|>`,
+  },
+
+  // The system prompt is put before the first turn, and the turn of the assistant is started like
+  // the others.
+  "openchat": {
+  template: `{{if hasSystem .Prompt}}{{trim .Prompt}}<|end_of_turn|>{{end}}{{history}}GPT4 Correct {{char}}:`,
+  stops: "<|end_of_turn|>",
+  },
+
+  // The system prompt is given the system role, and the turn of the assistant is started with its tag.
+  "phi3": {
+  template: `{{if hasSystem .Prompt}}<|system|>\n{{trim .Prompt}}<|end|>\n{{end}}{{history}}<|{{char}}|>\n`,
+  },
+
+  // The turns of the assistant are ended with the stop string, like the model is trained with.
+  "deepseekCoder": {
+  template: `{{prompt}}\n{{history}}### {{char}}:\n`,
+  historyTemplate: `### {{name}}:\n{{message}}\n`,
+  charMsgSuffix: "\n<|EOT|>",
+  },
+
+  // Turns are separated by blank lines, and the turn of the assistant is started like the others.
+  "nousHermes": {
+  template: `### Instruction: {{prompt}}\n\n{{history}}### {{char}}:\n`,
+  historyTemplate: `### {{name}}:\n{{message}}\n\n`,
+  },
+
+  // Like openchat.
+  "openchatMath": {
+  template: `{{if hasSystem .Prompt}}{{trim .Prompt}}<|end_of_turn|>{{end}}{{history}}Math Correct {{char}}:`,
+  stops: "<|end_of_turn|>",
+  },
+
+  // The sample conversation is replaced with the system prompt in the first turn of the human, and
+  // the turns of the assistant are closed with </s>.
+  "orion": {
+  template: `<s>Human: {{if hasSystem .Prompt}}{{trim .Prompt}}\n\n{{end}}{{history}}{{char}}: </s>`,
+  userTemplate: `{{message}}\n\n`,
+  charTemplate: `Assistant: </s>{{message}}</s>Human: `,
+  char: "Assistant",
+  userMsgSuffix: "",
+  },
+
+  // Like openchat.
+  "starlingCode": {
+  template: `{{if hasSystem .Prompt}}{{trim .Prompt}}<|end_of_turn|>{{end}}{{history}}Code {{char}}:`,
+  stops: "<|end_of_turn|>",
+  },
+
+  // The chat models of Yi are trained with ChatML.
+  "yi34b": {
+  template: `<|im_start|>system\n{{prompt}}<|im_end|>\n{{history}}<|im_start|>{{char}}\n`,
+  historyTemplate: `<|im_start|>{{name}}\n{{message}}<|im_end|>\n`,
+  char: "assistant",
+  user: "user",
+  stops: "<|im_end|>",
+  },
+
+  // ----------------------------
+
+  // The default model type. The system prompt is put in the first instruction, the turns of
+  // the assistant close the previous instruction.
+  "mistral": {
+  template: `<s>[INST] {{if hasSystem .Prompt}}{{prompt}}\n\n{{end}}{{history}} {{char}}`,
+
+  historyTemplate: `{{message}}`,
+  userTemplate: `{{message}}`,
+  charTemplate: ` [/INST] {{message}}</s>[INST] `,
+
+  char: "[/INST]",
+  charMsgPrefix: "",
+  charMsgSuffix: "",
+
+  user: "[INST]",
+  userMsgPrefix: "",
+  userMsgSuffix: "",
+
+  stops: ""
+  },
+
+  // ----------------------------
+
+  // Gemma has no system role, the system prompt is put in the first turn of the user, and every
+  // turn of the model is followed by the start of the user's one.
+  "gemma": {
+  template: `<bos><start_of_turn>user\n{{if hasSystem .Prompt}}{{trim .Prompt}}\n\n{{end}}{{history}}<start_of_turn>{{char}}\n`,
+
+  historyTemplate: `{{trim .Message}}<end_of_turn>\n`,
+  userTemplate: `{{trim .Message}}<end_of_turn>\n`,
+  charTemplate: `<start_of_turn>model\n{{trim .Message}}<end_of_turn>\n<start_of_turn>user\n`,
+
+  char: "model",
+  charMsgPrefix: "",
+  charMsgSuffix: "",
+
+  user: "user",
+  userMsgPrefix: "",
+  userMsgSuffix: "",
+
+  stops: "<end_of_turn>"
+  },
+};
+
+// Models of the formats, keyed by name like the options of prompt-models.html.
+export const promptModels = {
+  "Gemma": "gemma",
+};
@@ -0,0 +1,178 @@
+package llame
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+)
+
+// SignFormat is the gpg.format option of git config.
+type SignFormat string
+
+const (
+	SignFormatOpenPGP SignFormat = "openpgp"
+	SignFormatX509    SignFormat = "x509"
+	SignFormatSSH     SignFormat = "ssh"
+)
+
+// Signing signs commits with an external program, like git does. It implements git.Signer.
+type Signing struct {
+	Format  SignFormat
+	Program string
+	Key     string // user.signingkey, or the committer identity for gpg if it's not set
+}
+
+// GitSigning returns the signing settings of git config (gpg.format, user.signingkey and gpg.*.program).
+func GitSigning(repo *git.Repository, committer Identity) (*Signing, error) {
+	s := &Signing{Format: SignFormatOpenPGP}
+	if format, ok := GitConfigOption(repo, "gpg", "format"); ok && format != "" {
+		s.Format = SignFormat(strings.ToLower(format))
+	}
+
+	// Program options live in subsections ([gpg "ssh"]), that GitConfigOption doesn't look into.
+	programOption := func(subsection, fallback string) string {
+		if program, ok := gitSubsectionOption(repo, "gpg", subsection, "program"); ok && program != "" {
+			return program
+		}
+		return fallback
+	}
+
+	switch s.Format {
+	case SignFormatOpenPGP:
+		// gpg.program is the older name of gpg.openpgp.program.
+		fallback := "gpg"
+		if program, ok := GitConfigOption(repo, "gpg", "program"); ok && program != "" {
+			fallback = program
+		}
+		s.Program = programOption(string(SignFormatOpenPGP), fallback)
+	case SignFormatX509:
+		s.Program = programOption(string(SignFormatX509), "gpgsm")
+	case SignFormatSSH:
+		s.Program = programOption(string(SignFormatSSH), "ssh-keygen")
+	default:
+		return nil, fmt.Errorf("unsupported gpg.format %q", s.Format)
+	}
+
+	s.Key, _ = GitConfigOption(repo, "user", "signingKey")
+	if s.Key == "" {
+		if s.Format == SignFormatSSH {
+			return nil, errors.New("user.signingkey needs to be set for ssh signing")
+		}
+		s.Key = committer.String()
+	}
+
+	return s, nil
+}
+
+func gitSubsectionOption(repo *git.Repository, section, subsection, key string) (string, bool) {
+	// The system scope includes the global and the repository configs.
+	cfg, err := repo.ConfigScoped(config.SystemScope)
+	if err != nil || cfg.Raw == nil || !cfg.Raw.HasSection(section) {
+		return "", false
+	}
+
+	s := cfg.Raw.Section(section)
+	if !s.HasSubsection(subsection) {
+		return "", false
+	}
+
+	ss := s.Subsection(subsection)
+	if !ss.HasOption(key) {
+		return "", false
+	}
+
+	return ss.Option(key), true
+}
+
+// Sign implements git.Signer.
+func (s *Signing) Sign(message io.Reader) ([]byte, error) {
+	if s.Format == SignFormatSSH {
+		return s.signSSH(message)
+	}
+
+	// The same arguments git passes to gpg and gpgsm.
+	cmd := exec.Command(s.Program, "--status-fd=2", "-bsau", s.Key)
+	return runSigner(cmd, message)
+}
+
+// signSSH signs the message with `ssh-keygen -Y sign`, which reads the message from a file and
+// writes the signature next to it.
+func (s *Signing) signSSH(message io.Reader) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "llame-sign-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	msgPath := filepath.Join(dir, "message")
+	content, err := io.ReadAll(message)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(msgPath, content, 0o600); err != nil {
+		return nil, err
+	}
+
+	args := []string{"-Y", "sign", "-n", "git"}
+
+	keyPath, literal := sshKeyLiteral(s.Key)
+	if literal {
+		// A public key is given, the private one is expected in ssh-agent.
+		keyPath = filepath.Join(dir, "key.pub")
+		if err := os.WriteFile(keyPath, []byte(strings.TrimPrefix(s.Key, "key::")+"\n"), 0o600); err != nil {
+			return nil, err
+		}
+		args = append(args, "-U")
+	} else if rest, ok := strings.CutPrefix(keyPath, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		keyPath = filepath.Join(home, rest)
+	}
+
+	cmd := exec.Command(s.Program, append(args, "-f", keyPath, msgPath)...)
+	if _, err := runSigner(cmd, nil); err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(msgPath + ".sig")
+}
+
+// sshKeyLiteral reports whether the key is a public key itself rather than a path to a key file.
+func sshKeyLiteral(key string) (string, bool) {
+	if strings.HasPrefix(key, "key::") {
+		return "", true
+	}
+
+	for _, prefix := range []string{"ssh-", "ecdsa-", "sk-"} {
+		if strings.HasPrefix(key, prefix) {
+			return "", true
+		}
+	}
+
+	return key, false
+}
+
+func runSigner(cmd *exec.Cmd, stdin io.Reader) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	Debugf("Signing with %s", cmd)
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s failed to sign the data: %w: %s", filepath.Base(cmd.Path), err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
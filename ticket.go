@@ -0,0 +1,143 @@
+package llame
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// TicketPlacement is where ticket IDs are added to a commit message.
+type TicketPlacement string
+
+const (
+	TicketNone    TicketPlacement = "none"    // Only given to the model as context
+	TicketPrefix  TicketPlacement = "prefix"  // "PROJ-1234: Add login"
+	TicketScope   TicketPlacement = "scope"   // "feat(PROJ-1234): add login", a prefix if the subject isn't Conventional
+	TicketTrailer TicketPlacement = "trailer" // A "Refs: PROJ-1234" trailer
+)
+
+// DefaultTicketPatterns match Jira-like IDs, e.g. "PROJ-1234" of "feature/PROJ-1234-add-login".
+var DefaultTicketPatterns = []string{`[A-Z][A-Z0-9]+-[0-9]+`}
+
+// TicketOptions configure extracting ticket IDs from the branch name, see TicketConfig.
+type TicketOptions struct {
+	Patterns  []string // Regexps of IDs, the first group is the ID if there are groups
+	Placement TicketPlacement
+	Trailer   string // Key of the trailer
+}
+
+// Options returns the ticket options set in config.
+func (c TicketConfig) Options() TicketOptions {
+	return TicketOptions{
+		Patterns:  c.Patterns,
+		Placement: c.Placement,
+		Trailer:   c.Trailer,
+	}
+}
+
+// TicketRefs are the tickets a commit references.
+type TicketRefs struct {
+	Branch    string   // Empty if HEAD is detached
+	IDs       []string // In the order of the branch name
+	Placement TicketPlacement
+	Trailer   string
+}
+
+// NewTicketRefs extracts the ticket IDs from the branch name.
+func NewTicketRefs(branch string, opts TicketOptions) (TicketRefs, error) {
+	refs := TicketRefs{Branch: branch, Placement: opts.Placement, Trailer: opts.Trailer}
+
+	for _, p := range opts.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return refs, fmt.Errorf("invalid ticket pattern %q: %w", p, err)
+		}
+
+		for _, m := range re.FindAllStringSubmatch(branch, -1) {
+			id := m[0]
+			if len(m) > 1 {
+				id = m[1]
+			}
+			if id != "" && !slices.Contains(refs.IDs, id) {
+				refs.IDs = append(refs.IDs, id)
+			}
+		}
+	}
+
+	return refs, nil
+}
+
+// CurrentBranch returns the short name of the checked out branch, which may have no commits yet.
+// It's empty if HEAD is detached.
+func CurrentBranch(repo *git.Repository) (string, error) {
+	head, err := repo.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return "", err
+	}
+
+	if head.Type() == plumbing.SymbolicReference && head.Target().IsBranch() {
+		return head.Target().Short(), nil
+	}
+
+	return "", nil
+}
+
+// Add adds the IDs to the message according to the placement, unless the message
+// is empty or already mentions them.
+func (r TicketRefs) Add(msg string) string {
+	if len(r.IDs) == 0 || r.Placement == TicketNone || strings.TrimSpace(msg) == "" || r.mentioned(msg) {
+		return msg
+	}
+
+	if r.Placement == TicketTrailer {
+		return strings.TrimSuffix(addTrailer(msg, r.Trailer+": "+strings.Join(r.IDs, ", ")), "\n")
+	}
+
+	subject, body, hasBody := strings.Cut(strings.TrimLeft(msg, "\n"), "\n")
+	if s, err := ParseConventionalSubject(subject); err == nil && r.Placement == TicketScope {
+		s.Scopes = append(s.Scopes, r.IDs...)
+		subject = s.String()
+	} else {
+		subject = strings.Join(r.IDs, ", ") + ": " + subject
+	}
+
+	if hasBody {
+		return subject + "\n" + body
+	}
+	return subject
+}
+
+// mentioned reports whether all the IDs are in the message.
+func (r TicketRefs) mentioned(msg string) bool {
+	for _, id := range r.IDs {
+		if !strings.Contains(msg, id) {
+			return false
+		}
+	}
+	return true
+}
+
+// Strip removes the IDs added to the subject by Add, so the rest of it can be linted.
+func (r TicketRefs) Strip(msg string) string {
+	if len(r.IDs) == 0 {
+		return msg
+	}
+
+	subject, body, hasBody := strings.Cut(strings.TrimLeft(msg, "\n"), "\n")
+	subject = strings.TrimPrefix(subject, strings.Join(r.IDs, ", ")+": ")
+	if s, err := ParseConventionalSubject(subject); err == nil {
+		s.Scopes = slices.DeleteFunc(s.Scopes, func(scope string) bool {
+			return slices.Contains(r.IDs, scope)
+		})
+		subject = s.String()
+	}
+
+	if hasBody {
+		return subject + "\n" + body
+	}
+	return subject
+}
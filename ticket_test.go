@@ -0,0 +1,82 @@
+package llame
+
+import (
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTicketRefs(t *testing.T) {
+	opts := TicketOptions{Patterns: DefaultTicketPatterns}
+
+	for branch, want := range map[string][]string{
+		"feature/PROJ-1234-add-login":  {"PROJ-1234"},
+		"fix/PROJ-1-and-OPS-22-PROJ-1": {"PROJ-1", "OPS-22"},
+		"main":                         nil,
+		"":                             nil,
+	} {
+		refs, err := NewTicketRefs(branch, opts)
+		require.NoError(t, err)
+		assert.Equal(t, want, refs.IDs, branch)
+	}
+
+	refs, err := NewTicketRefs("fix/123-crash", TicketOptions{Patterns: []string{`^\w+/(\d+)-`}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"123"}, refs.IDs, "the group is the ID")
+
+	_, err = NewTicketRefs("main", TicketOptions{Patterns: []string{`(`}})
+	assert.ErrorContains(t, err, `invalid ticket pattern "("`)
+}
+
+func TestTicketRefsAdd(t *testing.T) {
+	refs := TicketRefs{IDs: []string{"PROJ-1"}, Trailer: "Refs"}
+
+	for _, tc := range []struct {
+		placement TicketPlacement
+		msg, want string
+	}{
+		{TicketNone, "Add login", "Add login"},
+		{TicketPrefix, "Add login", "PROJ-1: Add login"},
+		{TicketPrefix, "Add login\n\nBody.", "PROJ-1: Add login\n\nBody."},
+		{TicketPrefix, "PROJ-1 Add login", "PROJ-1 Add login"},
+		{TicketPrefix, "", ""},
+		{TicketScope, "feat: add login", "feat(PROJ-1): add login"},
+		{TicketScope, "feat(auth)!: add login", "feat(auth, PROJ-1)!: add login"},
+		{TicketScope, "Add login", "PROJ-1: Add login"},
+		{TicketTrailer, "Add login", "Add login\n\nRefs: PROJ-1"},
+		{TicketTrailer, "Add login\n\nBody.\n\nSigned-off-by: llame <llame@example.com>", "Add login\n\nBody.\n\nSigned-off-by: llame <llame@example.com>\nRefs: PROJ-1"},
+	} {
+		refs.Placement = tc.placement
+		assert.Equal(t, tc.want, refs.Add(tc.msg), "%s: %q", tc.placement, tc.msg)
+	}
+
+	refs.IDs = []string{"PROJ-1", "PROJ-2"}
+	refs.Placement = TicketPrefix
+	assert.Equal(t, "PROJ-1, PROJ-2: Add login", refs.Add("Add login"))
+	assert.Equal(t, "Add login", refs.Strip("PROJ-1, PROJ-2: Add login"))
+	assert.Equal(t, "feat(auth): add login", refs.Strip("feat(auth, PROJ-1, PROJ-2): add login"))
+	assert.Equal(t, "feat: add login\n\nBody.", refs.Strip("feat(PROJ-1,PROJ-2): add login\n\nBody."))
+
+	assert.Equal(t, "Add login", TicketRefs{}.Add("Add login"))
+}
+
+func TestCurrentBranch(t *testing.T) {
+	r := newMemRepo(t)
+	branch, err := CurrentBranch(r.repo)
+	require.NoError(t, err)
+	assert.Equal(t, "master", branch, "unborn")
+
+	hash := r.commitMsg("Initial commit", "README.md")
+	require.NoError(t, r.workTree.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName("feature/PROJ-1"), Create: true}))
+	branch, err = CurrentBranch(r.repo)
+	require.NoError(t, err)
+	assert.Equal(t, "feature/PROJ-1", branch)
+
+	require.NoError(t, r.workTree.Checkout(&git.CheckoutOptions{Hash: hash}))
+	branch, err = CurrentBranch(r.repo)
+	require.NoError(t, err)
+	assert.Empty(t, branch, "detached")
+}
@@ -0,0 +1,398 @@
+package llame
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+)
+
+// ChangeType is how a file is changed.
+type ChangeType string
+
+const (
+	ChangeAdd    ChangeType = "add"
+	ChangeModify ChangeType = "modify"
+	ChangeDelete ChangeType = "delete"
+	ChangeRename ChangeType = "rename"
+	ChangeCopy   ChangeType = "copy"
+	ChangeMode   ChangeType = "mode" // Only the file mode is changed
+)
+
+var InvalidDiffErr = errors.New("invalid diff")
+
+// Diff is a unified diff in the format of `git diff`, parsed by files and hunks.
+// It's rendered back as is with String, except for summarized files.
+type Diff struct {
+	Files []*FileDiff
+}
+
+// FileDiff is the part of a diff about a single file.
+type FileDiff struct {
+	OldPath, NewPath string // The same unless the file is renamed or copied
+	Change           ChangeType
+	OldMode, NewMode filemode.FileMode // Empty for added and deleted files respectively
+	Similarity       int               // Of renamed and copied files, in percent
+	Binary           bool
+
+	// Header holds the lines up to the first hunk, starting with "diff --git".
+	Header []string
+	Hunks  []*Hunk
+
+	Added, Deleted int // Lines
+
+	// Summarized files are rendered as a single line summary instead of their changes.
+	Summarized bool
+}
+
+// Hunk is a continuous part of a file diff.
+type Hunk struct {
+	OldStart, OldLines int
+	NewStart, NewLines int
+	Section            string // The text after the ranges, usually the enclosing function
+
+	// Lines are prefixed with ' ', '+', '-' or '\' (for "\ No newline at end of file").
+	Lines []string
+}
+
+// ParseDiff parses the output of `git diff` (with the a/ and b/ prefixes).
+func ParseDiff(text string) (*Diff, error) {
+	d := &Diff{}
+
+	lines := strings.Split(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	for i := 0; i < len(lines); {
+		if !strings.HasPrefix(lines[i], "diff --git ") {
+			return nil, fmt.Errorf("%w: line %d: expected a file header, got %q", InvalidDiffErr, i+1, lines[i])
+		}
+
+		f, n, err := parseFileDiff(lines[i:])
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %w", InvalidDiffErr, i+1+n, err)
+		}
+
+		d.Files = append(d.Files, f)
+		i += n
+	}
+
+	return d, nil
+}
+
+// parseFileDiff parses the diff of the first file in lines, returning the number of lines it takes.
+// On errors, the number of lines is the one of the invalid line.
+func parseFileDiff(lines []string) (*FileDiff, int, error) {
+	f := &FileDiff{Header: []string{lines[0]}}
+	f.OldPath, f.NewPath = parseGitHeaderPaths(strings.TrimPrefix(lines[0], "diff --git "))
+
+	i := 1
+	for ; i < len(lines) && !strings.HasPrefix(lines[i], "@@") && !strings.HasPrefix(lines[i], "diff --git "); i++ {
+		line := lines[i]
+		f.Header = append(f.Header, line)
+
+		var err error
+		switch {
+		case strings.HasPrefix(line, "old mode "):
+			f.OldMode, err = filemode.New(strings.TrimPrefix(line, "old mode "))
+		case strings.HasPrefix(line, "new mode "):
+			f.NewMode, err = filemode.New(strings.TrimPrefix(line, "new mode "))
+		case strings.HasPrefix(line, "new file mode "):
+			f.Change = ChangeAdd
+			f.NewMode, err = filemode.New(strings.TrimPrefix(line, "new file mode "))
+		case strings.HasPrefix(line, "deleted file mode "):
+			f.Change = ChangeDelete
+			f.OldMode, err = filemode.New(strings.TrimPrefix(line, "deleted file mode "))
+		case strings.HasPrefix(line, "rename from "):
+			f.Change, f.OldPath = ChangeRename, unquotePath(strings.TrimPrefix(line, "rename from "))
+		case strings.HasPrefix(line, "rename to "):
+			f.Change, f.NewPath = ChangeRename, unquotePath(strings.TrimPrefix(line, "rename to "))
+		case strings.HasPrefix(line, "copy from "):
+			f.Change, f.OldPath = ChangeCopy, unquotePath(strings.TrimPrefix(line, "copy from "))
+		case strings.HasPrefix(line, "copy to "):
+			f.Change, f.NewPath = ChangeCopy, unquotePath(strings.TrimPrefix(line, "copy to "))
+		case strings.HasPrefix(line, "similarity index "):
+			f.Similarity, err = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(line, "similarity index "), "%"))
+		case strings.HasPrefix(line, "index "):
+			// "index <old>..<new> <mode>", the mode is given if it's unchanged.
+			if _, mode, ok := strings.Cut(strings.TrimPrefix(line, "index "), " "); ok {
+				f.NewMode, err = filemode.New(mode)
+				f.OldMode = f.NewMode
+			}
+		case strings.HasPrefix(line, "Binary files ") || line == "GIT binary patch":
+			f.Binary = true
+		case strings.HasPrefix(line, "--- "):
+			if p := strings.TrimPrefix(line, "--- "); p != "/dev/null" {
+				f.OldPath = strings.TrimPrefix(unquotePath(p), "a/")
+			}
+		case strings.HasPrefix(line, "+++ "):
+			if p := strings.TrimPrefix(line, "+++ "); p != "/dev/null" {
+				f.NewPath = strings.TrimPrefix(unquotePath(p), "b/")
+			}
+		}
+		if err != nil {
+			return nil, i, err
+		}
+	}
+
+	for i < len(lines) && strings.HasPrefix(lines[i], "@@") {
+		h, err := parseHunkHeader(lines[i])
+		if err != nil {
+			return nil, i, err
+		}
+		i++
+
+		oldLeft, newLeft := h.OldLines, h.NewLines
+		for i < len(lines) && (oldLeft > 0 || newLeft > 0 || strings.HasPrefix(lines[i], `\`)) {
+			line := lines[i]
+			switch {
+			case strings.HasPrefix(line, "+"):
+				newLeft--
+				f.Added++
+			case strings.HasPrefix(line, "-"):
+				oldLeft--
+				f.Deleted++
+			case strings.HasPrefix(line, `\`):
+			case strings.HasPrefix(line, " ") || line == "":
+				// Empty context lines may have lost their space, e.g. in an editor.
+				oldLeft--
+				newLeft--
+			default:
+				return nil, i, fmt.Errorf("unexpected line in a hunk: %q", line)
+			}
+			h.Lines = append(h.Lines, line)
+			i++
+		}
+		if oldLeft != 0 || newLeft != 0 {
+			return nil, i, fmt.Errorf("hunk %q doesn't match its line counts", h.header())
+		}
+
+		f.Hunks = append(f.Hunks, h)
+	}
+
+	if i < len(lines) && !strings.HasPrefix(lines[i], "diff --git ") {
+		return nil, i, fmt.Errorf("unexpected line after hunks: %q", lines[i])
+	}
+
+	if f.Change == "" {
+		f.Change = ChangeModify
+		if len(f.Hunks) == 0 && !f.Binary && f.OldMode != f.NewMode {
+			f.Change = ChangeMode
+		}
+	}
+
+	return f, i, nil
+}
+
+// parseGitHeaderPaths splits "a/<old> b/<new>" of the "diff --git" line. The paths are ambiguous
+// if they contain " b/", it's the best guess that is corrected by the following header lines.
+func parseGitHeaderPaths(s string) (string, string) {
+	if strings.HasPrefix(s, `"`) {
+		// Quoted paths don't contain unescaped quotes.
+		if end := strings.Index(s[1:], `" `); end != -1 {
+			oldPath, newPath := s[:end+2], s[end+3:]
+			return strings.TrimPrefix(unquotePath(oldPath), "a/"), strings.TrimPrefix(unquotePath(newPath), "b/")
+		}
+	}
+
+	s = strings.TrimPrefix(s, "a/")
+	// Prefer the split where both paths are the same, as they are for all but renames and copies.
+	for i := 0; ; i++ {
+		j := strings.Index(s[i:], " b/")
+		if j == -1 {
+			break
+		}
+		i += j
+		if s[:i] == s[i+len(" b/"):] {
+			return s[:i], s[:i]
+		}
+	}
+
+	if oldPath, newPath, ok := strings.Cut(s, " b/"); ok {
+		return oldPath, newPath
+	}
+
+	return s, s
+}
+
+// unquotePath unquotes paths git quotes for special characters (core.quotePath).
+func unquotePath(p string) string {
+	if !strings.HasPrefix(p, `"`) {
+		return p
+	}
+
+	if unquoted, err := strconv.Unquote(p); err == nil {
+		return unquoted
+	}
+
+	return p
+}
+
+var hunkHeaderRe = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@ ?(.*)$`)
+
+func parseHunkHeader(line string) (*Hunk, error) {
+	m := hunkHeaderRe.FindStringSubmatch(line)
+	if m == nil {
+		return nil, fmt.Errorf("invalid hunk header %q", line)
+	}
+
+	// Counts are omitted if they are 1.
+	atoi := func(s string) int {
+		if s == "" {
+			return 1
+		}
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+
+	return &Hunk{
+		OldStart: atoi(m[1]),
+		OldLines: atoi(m[2]),
+		NewStart: atoi(m[3]),
+		NewLines: atoi(m[4]),
+		Section:  m[5],
+	}, nil
+}
+
+func (h *Hunk) header() string {
+	hunkRange := func(start, lines int) string {
+		if lines == 1 {
+			return strconv.Itoa(start)
+		}
+		return fmt.Sprintf("%d,%d", start, lines)
+	}
+
+	header := fmt.Sprintf("@@ -%s +%s @@", hunkRange(h.OldStart, h.OldLines), hunkRange(h.NewStart, h.NewLines))
+	if h.Section != "" {
+		header += " " + h.Section
+	}
+
+	return header
+}
+
+// Path is the path of the file after the change.
+func (f *FileDiff) Path() string {
+	if f.Change == ChangeDelete {
+		return f.OldPath
+	}
+
+	return f.NewPath
+}
+
+// Language guesses the programming language of the file by its name, it's empty if unknown.
+func (f *FileDiff) Language() string {
+	name := path.Base(f.Path())
+	if lang, ok := languageFileNames[name]; ok {
+		return lang
+	}
+
+	return languageExtensions[strings.ToLower(path.Ext(name))]
+}
+
+var languageFileNames = map[string]string{
+	"Makefile":   "Makefile",
+	"Dockerfile": "Dockerfile",
+	"go.mod":     "Go Module",
+	"go.sum":     "Go Checksums",
+}
+
+var languageExtensions = map[string]string{
+	".go":    "Go",
+	".c":     "C",
+	".h":     "C",
+	".cc":    "C++",
+	".cpp":   "C++",
+	".hpp":   "C++",
+	".cs":    "C#",
+	".java":  "Java",
+	".kt":    "Kotlin",
+	".swift": "Swift",
+	".rs":    "Rust",
+	".py":    "Python",
+	".rb":    "Ruby",
+	".php":   "PHP",
+	".js":    "JavaScript",
+	".jsx":   "JavaScript",
+	".mjs":   "JavaScript",
+	".ts":    "TypeScript",
+	".tsx":   "TypeScript",
+	".lua":   "Lua",
+	".sh":    "Shell",
+	".bash":  "Shell",
+	".sql":   "SQL",
+	".html":  "HTML",
+	".css":   "CSS",
+	".scss":  "SCSS",
+	".md":    "Markdown",
+	".json":  "JSON",
+	".yaml":  "YAML",
+	".yml":   "YAML",
+	".toml":  "TOML",
+	".xml":   "XML",
+	".proto": "Protocol Buffers",
+}
+
+// Summary describes the change in a line, like "go.sum: 40 lines changed".
+func (f *FileDiff) Summary() string {
+	if f.Binary {
+		return f.Path() + ": binary file changed"
+	}
+
+	if changed := f.Added + f.Deleted; changed != 1 {
+		return fmt.Sprintf("%s: %d lines changed", f.Path(), changed)
+	}
+
+	return f.Path() + ": 1 line changed"
+}
+
+func (f *FileDiff) String() string {
+	if f.Summarized {
+		return f.Summary() + "\n"
+	}
+
+	var sb strings.Builder
+	for _, line := range f.Header {
+		sb.WriteString(line + "\n")
+	}
+	for _, h := range f.Hunks {
+		sb.WriteString(h.header() + "\n")
+		for _, line := range h.Lines {
+			sb.WriteString(line + "\n")
+		}
+	}
+
+	return sb.String()
+}
+
+// String renders the diff, it's empty for a nil diff.
+func (d *Diff) String() string {
+	if d == nil {
+		return ""
+	}
+
+	var sb strings.Builder
+	for _, f := range d.Files {
+		sb.WriteString(f.String())
+	}
+
+	return sb.String()
+}
+
+// Stats returns the number of changed files and lines.
+func (d *Diff) Stats() (files, added, deleted int) {
+	if d == nil {
+		return 0, 0, 0
+	}
+
+	for _, f := range d.Files {
+		added += f.Added
+		deleted += f.Deleted
+	}
+
+	return len(d.Files), added, deleted
+}
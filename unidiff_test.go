@@ -0,0 +1,178 @@
+package llame
+
+import (
+	"context"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gitT runs git in the working directory.
+func gitT(t *testing.T, args ...string) string {
+	out, err := exec.Command("git", args...).CombinedOutput()
+	require.NoError(t, err, string(out))
+	return string(out)
+}
+
+func writeFileT(t *testing.T, name, content string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(name), 0o755))
+	require.NoError(t, os.WriteFile(name, []byte(content), 0o644))
+}
+
+// initChangesRepo stages all kinds of changes against the HEAD commit.
+func initChangesRepo(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git isn't installed")
+	}
+	initTestRepo(t)
+
+	writeFileT(t, "main.go", numberedLines(1, 100))
+	writeFileT(t, "cmd/old/main.go", numberedLines(1, 30))
+	writeFileT(t, "moved.txt", numberedLines(1, 10))
+	writeFileT(t, "deleted.txt", "deleted\n")
+	writeFileT(t, "script.sh", "echo\n")
+	writeFileT(t, "go.sum", numberedLines(1, 300))
+	gitT(t, "add", ".")
+	gitT(t, "commit", "-q", "-m", "Initial commit")
+
+	content := strings.Replace(numberedLines(1, 100), "line 5\n", "line five\n", 1)
+	content = strings.Replace(content, "line 90\n", "", 1)
+	writeFileT(t, "main.go", content+"no newline")
+	writeFileT(t, "cmd/new/main.go", numberedLines(1, 29)+"line changed\n")
+	require.NoError(t, os.RemoveAll("cmd/old"))
+	require.NoError(t, os.Rename("moved.txt", "moved too.txt"))
+	require.NoError(t, os.Remove("deleted.txt"))
+	require.NoError(t, os.Chmod("script.sh", 0o755))
+	writeFileT(t, "go.sum", numberedLines(1, 150)+numberedLines(1000, 1200))
+	writeFileT(t, "logo.png", "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	writeFileT(t, "docs/new.md", "# New\n")
+	gitT(t, "add", "-A")
+}
+
+func TestParseDiff(t *testing.T) {
+	initChangesRepo(t)
+	writeFileT(t, "ünïcode.txt", "quoted\n")
+	gitT(t, "add", "-A")
+
+	text, err := execDiffStaged(context.Background(), "HEAD", DefaultDiffOptions())
+	require.NoError(t, err)
+
+	d, err := ParseDiff(string(text))
+	require.NoError(t, err)
+	assert.Equal(t, string(text), d.String(), "diffs are rendered back as is")
+
+	files := make(map[string]*FileDiff)
+	for _, f := range d.Files {
+		files[f.Path()] = f
+	}
+	require.Equal(t, 9, len(d.Files), strings.Join(slices.Collect(maps.Keys(files)), ", "))
+
+	main := files["main.go"]
+	assert.Equal(t, ChangeModify, main.Change)
+	assert.Equal(t, filemode.Regular, main.NewMode)
+	assert.Equal(t, 2, main.Added)
+	assert.Equal(t, 2, main.Deleted)
+	assert.Equal(t, "Go", main.Language())
+	require.Len(t, main.Hunks, 3)
+	assert.Equal(t, Hunk{
+		OldStart: 2, OldLines: 7, NewStart: 2, NewLines: 7, Section: "line 1",
+		Lines: []string{" line 2", " line 3", " line 4", "-line 5", "+line five", " line 6", " line 7", " line 8"},
+	}, *main.Hunks[0])
+	assert.Equal(t, `\ No newline at end of file`, main.Hunks[2].Lines[len(main.Hunks[2].Lines)-1])
+
+	renamed := files["cmd/new/main.go"]
+	assert.Equal(t, ChangeRename, renamed.Change)
+	assert.Equal(t, "cmd/old/main.go", renamed.OldPath)
+	assert.Equal(t, 94, renamed.Similarity)
+
+	assert.Equal(t, ChangeRename, files["moved too.txt"].Change)
+	assert.Equal(t, "moved.txt", files["moved too.txt"].OldPath)
+	assert.Empty(t, files["moved too.txt"].Hunks)
+
+	assert.Equal(t, ChangeDelete, files["deleted.txt"].Change)
+	assert.Equal(t, 1, files["deleted.txt"].Deleted)
+
+	mode := files["script.sh"]
+	assert.Equal(t, ChangeMode, mode.Change)
+	assert.Equal(t, filemode.Regular, mode.OldMode)
+	assert.Equal(t, filemode.Executable, mode.NewMode)
+
+	assert.Equal(t, ChangeAdd, files["logo.png"].Change)
+	assert.True(t, files["logo.png"].Binary)
+	assert.Equal(t, ChangeAdd, files["docs/new.md"].Change)
+	assert.Equal(t, "Markdown", files["docs/new.md"].Language())
+	assert.Equal(t, ChangeAdd, files["ünïcode.txt"].Change)
+
+	n, added, deleted := d.Stats()
+	assert.Equal(t, 9, n)
+	assert.Equal(t, 2+1+1+201+1, added)
+	assert.Equal(t, 2+1+1+150, deleted)
+
+	t.Run("native", func(t *testing.T) {
+		native, err := GitDiffStaged(context.Background(), DefaultDiffOptions())
+		require.NoError(t, err)
+		require.Equal(t, len(d.Files), len(native.Files))
+		assert.True(t, native.Files[3].Summarized, "go.sum is filtered")
+
+		for i, f := range native.Files {
+			assert.Equal(t, d.Files[i].Path(), f.Path())
+			assert.Equal(t, d.Files[i].Change, f.Change, f.Path())
+			assert.Equal(t, d.Files[i].Added, f.Added, f.Path())
+			assert.Equal(t, d.Files[i].Deleted, f.Deleted, f.Path())
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, text := range []string{
+			"random text\n",
+			"diff --git a/file b/file\n@@ -1,2 +1,2 @@\n line\n",
+			"diff --git a/file b/file\n@@ -1 +1 @@\n-old\n+new\nextra\n",
+			"diff --git a/file b/file\n@@ invalid @@\n",
+		} {
+			_, err := ParseDiff(text)
+			assert.ErrorIs(t, err, InvalidDiffErr, text)
+		}
+	})
+}
+
+var binStatRe = regexp.MustCompile(`Bin \d+ -> \d+ bytes`)
+
+func TestDiffStat(t *testing.T) {
+	initChangesRepo(t)
+
+	text, err := execDiffStaged(context.Background(), "HEAD", DefaultDiffOptions())
+	require.NoError(t, err)
+	d, err := ParseDiff(string(text))
+	require.NoError(t, err)
+
+	for _, width := range []int{80, 50, 20, 200} {
+		want := gitT(t, "diff", "--staged", "-M", "--stat="+strconv.Itoa(width), "HEAD")
+		// Sizes of binary files aren't known.
+		want = binStatRe.ReplaceAllString(want, "Bin")
+		assert.Equal(t, want, d.Stat(width), "width %d", width)
+	}
+
+	assert.Equal(t, " 0 files changed\n", (*Diff)(nil).Stat(80))
+}
+
+func TestRenameName(t *testing.T) {
+	for _, tc := range []struct{ from, to, want string }{
+		{"a.txt", "b.txt", "a.txt => b.txt"},
+		{"cmd/old/main.go", "cmd/new/main.go", "cmd/{old => new}/main.go"},
+		{"main.go", "cmd/main.go", "main.go => cmd/main.go"},
+		{"dir/a.go", "dir/b.go", "dir/{a.go => b.go}"},
+		{"a/b/c.go", "a/c.go", "a/{b => }/c.go"},
+	} {
+		assert.Equal(t, tc.want, renameName(tc.from, tc.to))
+	}
+}